@@ -0,0 +1,217 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"lite-ide/internal/vfs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+var ErrUnknownSignal = errors.New("unknown signal")
+
+// Options describe a session to start. Zero values start the workspace's
+// default profile, or the user's shell without a root, at 80x24.
+type Options struct {
+	Name    string            `json:"name"`
+	Root    string            `json:"root"`    // Local workspace whose config and env files apply
+	Cwd     string            `json:"cwd"`     // Inside Root when given, else any directory
+	Profile string            `json:"profile"` // A profile from the workspace config
+	Shell   string            `json:"shell"`   // A command to run instead of a profile
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"` // Added last, over env files and the profile's env
+	Cols    uint16            `json:"cols"`
+	Rows    uint16            `json:"rows"`
+}
+
+// Info describes a running session
+type Info struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Profile string       `json:"profile,omitempty"`
+	PID     int          `json:"pid"`
+	Command string       `json:"command"`
+	Args    []string     `json:"args"`
+	Cwd     string       `json:"cwd"`
+	Started time.Time    `json:"startedAt"`
+	Cols    uint16       `json:"cols"`
+	Rows    uint16       `json:"rows"`
+	Clients []ClientInfo `json:"clients"`
+}
+
+// ClientInfo describes a WebSocket attached to a session
+type ClientInfo struct {
+	Addr  string    `json:"addr"`
+	Since time.Time `json:"since"`
+}
+
+// Create starts a session.
+func Create(opts Options) (*Session, error) {
+	dir := opts.Cwd
+	config := workspaceConfig{}
+	if opts.Root != "" {
+		if !vfs.IsLocal(opts.Root) {
+			return nil, ErrRemoteRoot
+		}
+		var err error
+		if config, err = loadWorkspaceConfig(opts.Root); err != nil {
+			return nil, err
+		}
+		dir, _ = vfs.LocalPath(opts.Root, path.Clean("/"+opts.Cwd))
+		if dir, err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", opts.Cwd)
+		}
+	}
+
+	var profile Profile
+	if opts.Profile != "" {
+		var err error
+		if profile, err = config.profile(opts.Profile); err != nil {
+			return nil, err
+		}
+	} else if opts.Shell == "" && config.DefaultProfile != "" {
+		var err error
+		if profile, err = config.profile(config.DefaultProfile); err != nil {
+			return nil, err
+		}
+	}
+	shell, args := opts.Shell, opts.Args
+	if shell == "" {
+		shell = profile.Command
+		if args == nil {
+			args = profile.Args
+		}
+	}
+	if shell == "" {
+		shell = defaultShell()
+	}
+
+	// Later variables override earlier ones
+	env := os.Environ()
+	if opts.Root != "" {
+		vars, err := config.envFileVars(opts.Root)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, vars...)
+	}
+	env = append(env, envPairs(profile.Env)...)
+	env = append(env, envPairs(opts.Env)...)
+
+	log.Printf("Starting shell: %s", shell)
+	cmd := exec.Command(shell, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	name := opts.Name
+	if name == "" {
+		name = profile.Name
+	}
+	if name == "" {
+		name = filepath.Base(shell)
+	}
+	size := pty.Winsize{Cols: opts.Cols, Rows: opts.Rows}
+	if size.Cols == 0 || size.Rows == 0 {
+		size = pty.Winsize{Cols: 80, Rows: 24}
+	}
+	s, err := startSession(cmd, name, profile.Name, size)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Terminal session %s started", s.ID)
+	return s, nil
+}
+
+// defaultShell returns the user's shell, falling back to common shells.
+func defaultShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	if _, err := os.Stat("/bin/zsh"); err == nil {
+		return "/bin/zsh"
+	}
+	return "/bin/bash"
+}
+
+// List returns the running sessions, oldest first.
+func List() []Info {
+	sessions.Lock()
+	running := make([]*Session, 0, len(sessions.byID))
+	for _, s := range sessions.byID {
+		running = append(running, s)
+	}
+	sessions.Unlock()
+
+	infos := make([]Info, 0, len(running))
+	for _, s := range running {
+		infos = append(infos, s.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos
+}
+
+// Info describes the session.
+func (s *Session) Info() Info {
+	cwd := s.cmd.Dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := Info{
+		ID:      s.ID,
+		Name:    s.name,
+		Profile: s.profile,
+		PID:     s.cmd.Process.Pid,
+		Command: s.cmd.Path,
+		Args:    slices.Clone(s.cmd.Args[1:]),
+		Cwd:     cwd,
+		Started: s.Started,
+		Cols:    s.size.Cols,
+		Rows:    s.size.Rows,
+		Clients: make([]ClientInfo, 0, len(s.clients)),
+	}
+	for c := range s.clients {
+		info.Clients = append(info.Clients, ClientInfo{Addr: c.addr, Since: c.since})
+	}
+	sort.Slice(info.Clients, func(i, j int) bool { return info.Clients[i].Since.Before(info.Clients[j].Since) })
+	return info
+}
+
+// Rename sets the session's display name.
+func (s *Session) Rename(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// Kill ends the session; attached clients get its exit.
+func (s *Session) Kill() error {
+	return s.cmd.Process.Kill()
+}
+
+func (s *Session) resize(cols, rows uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = pty.Winsize{Cols: cols, Rows: rows}
+	if err := pty.Setsize(s.tty, &s.size); err != nil {
+		log.Printf("failed to set pty size: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Signal sends a signal, given by name ("INT", "SIGTERM") or number, to the
+// shell or, with foreground, to the job running in the terminal the way
+// typing Ctrl-C would.
+func (s *Session) Signal(name string, foreground bool) error {
+	sig, err := parseSignal(name)
+	if err != nil {
+		return err
+	}
+	if !foreground {
+		return s.cmd.Process.Signal(sig)
+	}
+
+	var pgrp int
+	conn, err := s.tty.SyscallConn()
+	if err != nil {
+		return err
+	}
+	controlErr := conn.Control(func(fd uintptr) {
+		pgrp, err = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	if err != nil {
+		return err
+	}
+	return syscall.Kill(-pgrp, sig)
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownSignal, name)
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package terminal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Signal isn't supported on Windows, which has no signals to send to a
+// console's processes.
+func (s *Session) Signal(name string, foreground bool) error {
+	return fmt.Errorf("signals: %w", errors.ErrUnsupported)
+}
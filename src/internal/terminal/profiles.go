@@ -0,0 +1,219 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lite-ide/internal/vfs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// workspaceConfigFile is the per-workspace config at the root; terminal
+// settings live under its "terminal" key
+const workspaceConfigFile = ".nanoide.json"
+
+var (
+	ErrUnknownProfile = errors.New("unknown terminal profile")
+	ErrRemoteRoot     = errors.New("terminals need a local workspace")
+)
+
+// Profile is a command the terminal can start, such as a shell or a REPL
+type Profile struct {
+	Name    string            `json:"name"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Default bool              `json:"default,omitempty"` // Started when no profile is asked for
+}
+
+// workspaceConfig is the "terminal" section of the workspace config
+type workspaceConfig struct {
+	Profiles       []Profile `json:"profiles"`
+	DefaultProfile string    `json:"defaultProfile"`
+	EnvFiles       []string  `json:"envFiles"` // Relative to the root; defaults to .env
+}
+
+// builtinProfiles are offered when their command is installed
+var builtinProfiles = []Profile{
+	{Name: "bash", Command: "bash"},
+	{Name: "zsh", Command: "zsh"},
+	{Name: "sh", Command: "sh"},
+	{Name: "python", Command: "python3"},
+}
+
+// loadWorkspaceConfig reads the terminal section of the workspace config.
+// A workspace that isn't trusted gets only the built-in profiles and no env
+// files: its profiles would start its own commands, and env files can set
+// variables such as BASH_ENV, PROMPT_COMMAND or LD_PRELOAD.
+func loadWorkspaceConfig(root string) (workspaceConfig, error) {
+	if !vfs.Trusted(root) {
+		return workspaceConfig{}, nil
+	}
+	config := workspaceConfig{EnvFiles: []string{".env"}}
+	data, err := os.ReadFile(filepath.Join(root, workspaceConfigFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return config, err
+	}
+	var file struct {
+		Terminal *workspaceConfig `json:"terminal"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return config, fmt.Errorf("%s: %w", workspaceConfigFile, err)
+	}
+	if file.Terminal == nil {
+		return config, nil
+	}
+	if file.Terminal.EnvFiles == nil {
+		file.Terminal.EnvFiles = config.EnvFiles
+	}
+	for i, profile := range file.Terminal.Profiles {
+		if profile.Name == "" || profile.Command == "" {
+			return config, fmt.Errorf("%s: terminal profile %d needs a name and a command", workspaceConfigFile, i+1)
+		}
+	}
+	return *file.Terminal, nil
+}
+
+// profiles returns the installed built-in profiles followed by the
+// workspace's, which replace built-ins of the same name. The default is the
+// configured one, or else the one running the user's shell.
+func (c workspaceConfig) profiles() []Profile {
+	var profiles []Profile
+	for _, profile := range builtinProfiles {
+		if _, err := exec.LookPath(profile.Command); err == nil {
+			profiles = append(profiles, profile)
+		}
+	}
+	for _, profile := range c.Profiles {
+		replaced := false
+		for i := range profiles {
+			if profiles[i].Name == profile.Name {
+				profiles[i], replaced = profile, true
+			}
+		}
+		if !replaced {
+			profiles = append(profiles, profile)
+		}
+	}
+
+	defaultName := c.DefaultProfile
+	if defaultName == "" {
+		defaultName = filepath.Base(defaultShell())
+	}
+	for i := range profiles {
+		profiles[i].Default = profiles[i].Name == defaultName
+	}
+	return profiles
+}
+
+func (c workspaceConfig) profile(name string) (Profile, error) {
+	for _, profile := range c.profiles() {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+}
+
+// Profiles returns the profiles available in a workspace.
+func Profiles(root string) ([]Profile, error) {
+	if !vfs.IsLocal(root) {
+		return nil, ErrRemoteRoot
+	}
+	config, err := loadWorkspaceConfig(root)
+	if err != nil {
+		return nil, err
+	}
+	return config.profiles(), nil
+}
+
+// envFileVars reads the workspace's env files in order, as KEY=value pairs.
+// Missing files are skipped.
+func (c workspaceConfig) envFileVars(root string) ([]string, error) {
+	var env []string
+	for _, name := range c.EnvFiles {
+		local, _ := vfs.LocalPath(root, path.Clean("/"+name))
+		vars, err := readEnvFile(local)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		env = append(env, vars...)
+	}
+	return env, nil
+}
+
+// readEnvFile parses a dotenv file: KEY=value lines, optionally prefixed
+// with "export", with # comments. Double-quoted values may use \n, \t, \"
+// and \\ escapes; single-quoted values are taken literally. Nothing is
+// expanded.
+func readEnvFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=value", line)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := 1
+			for end < len(value) && value[end] != '"' {
+				if value[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(value) {
+				return nil, fmt.Errorf("line %d: unterminated quote", line)
+			}
+			value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value[1:end])
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quote", line)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// envPairs turns a map of variables into sorted KEY=value pairs.
+func envPairs(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for key, value := range vars {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
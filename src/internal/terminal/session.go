@@ -0,0 +1,326 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxScrollback is how much recent output a session keeps for clients
+	// that attach later
+	maxScrollback = 256 * 1024
+
+	// clientQueueSize is how many output chunks may wait for a slow client
+	// before it is disconnected; it gets the scrollback when it reattaches
+	clientQueueSize = 256
+
+	// defaultIdleTimeout is how long a session without clients keeps
+	// running unless NANO_IDE_TERMINAL_IDLE says otherwise
+	defaultIdleTimeout = 30 * time.Minute
+)
+
+// controlMessage is sent to clients as a text message; terminal output is
+// sent as binary messages
+type controlMessage struct {
+	Type    string `json:"type"` // "session" on attach, "exit" when the shell ended, "error" when it couldn't start
+	ID      string `json:"id,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Session is a shell running in a PTY. It outlives the WebSocket
+// connections attached to it and keeps its recent output for the next one.
+type Session struct {
+	ID      string
+	Started time.Time
+	cmd     *exec.Cmd
+	tty     *os.File
+
+	mu         sync.Mutex
+	name       string
+	profile    string
+	size       pty.Winsize
+	scrollback ring
+	clients    map[*client]bool
+	idleTimer  *time.Timer
+	exited     bool
+}
+
+// client is one WebSocket attached to a session. Only its writer goroutine
+// writes to the connection; the session hands it messages over out.
+type client struct {
+	addr   string
+	since  time.Time
+	out    chan wsMessage
+	closed bool // out was closed, guarded by the session's mu
+}
+
+type wsMessage struct {
+	kind int
+	data []byte
+}
+
+var sessions = struct {
+	sync.Mutex
+	byID map[string]*Session
+}{byID: make(map[string]*Session)}
+
+// Lookup returns a running session by ID.
+func Lookup(id string) *Session {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return sessions.byID[id]
+}
+
+// startSession starts cmd in a new PTY and registers the session.
+func startSession(cmd *exec.Cmd, name, profile string, size pty.Winsize) (*Session, error) {
+	tty, err := pty.StartWithSize(cmd, &size)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	s := &Session{
+		ID:      hex.EncodeToString(id),
+		Started: time.Now(),
+		cmd:     cmd,
+		tty:     tty,
+		name:    name,
+		profile: profile,
+		size:    size,
+		clients: make(map[*client]bool),
+	}
+	// Reaped if nobody attaches
+	s.mu.Lock()
+	s.startIdleTimer()
+	s.mu.Unlock()
+	sessions.Lock()
+	sessions.byID[s.ID] = s
+	sessions.Unlock()
+
+	go s.pump()
+	return s, nil
+}
+
+func idleTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("NANO_IDE_TERMINAL_IDLE")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultIdleTimeout
+}
+
+// pump copies PTY output to the scrollback and the attached clients until
+// the shell exits.
+func (s *Session) pump() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.tty.Read(buf)
+		if n > 0 {
+			data := append([]byte(nil), buf[:n]...)
+			s.mu.Lock()
+			s.scrollback.write(data)
+			for c := range s.clients {
+				s.send(c, wsMessage{websocket.BinaryMessage, data})
+			}
+			s.mu.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	s.cmd.Wait()
+	code := s.cmd.ProcessState.ExitCode()
+	log.Printf("Terminal session %s exited with code %d", s.ID, code)
+
+	sessions.Lock()
+	delete(sessions.byID, s.ID)
+	sessions.Unlock()
+
+	s.mu.Lock()
+	s.exited = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	exit, _ := jsonMessage(controlMessage{Type: "exit", Code: code})
+	for c := range s.clients {
+		s.send(c, exit)
+		s.drop(c)
+	}
+	s.mu.Unlock()
+	s.tty.Close()
+}
+
+// send queues a message for a client, dropping clients that fell too far
+// behind. Called with mu held.
+func (s *Session) send(c *client, message wsMessage) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.out <- message:
+	default:
+		log.Printf("Terminal client of session %s fell behind, disconnecting it", s.ID)
+		s.drop(c)
+	}
+}
+
+// drop detaches a client and ends its writer. Called with mu held.
+func (s *Session) drop(c *client) {
+	if !c.closed {
+		c.closed = true
+		close(c.out)
+	}
+	delete(s.clients, c)
+	if len(s.clients) == 0 && !s.exited {
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+		}
+		s.startIdleTimer()
+	}
+}
+
+// attach adds a client, returning the output it missed.
+func (s *Session) attach(addr string) (*client, []byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exited {
+		return nil, nil, false
+	}
+	c := &client{addr: addr, since: time.Now(), out: make(chan wsMessage, clientQueueSize)}
+	s.clients[c] = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	return c, s.scrollback.bytes(), true
+}
+
+func (s *Session) detach(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c] {
+		s.drop(c)
+	}
+}
+
+// startIdleTimer reaps the session after the idle timeout unless a client
+// attaches first. Called with mu held.
+func (s *Session) startIdleTimer() {
+	var timer *time.Timer
+	timer = time.AfterFunc(idleTimeout(), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.reapIfIdle(timer)
+	})
+	s.idleTimer = timer
+}
+
+// reapIfIdle ends a session nobody has attached to for the idle timeout.
+// The check and the kill happen under one hold of mu, and a timer that was
+// replaced or stopped after it fired does nothing, so a client attaching
+// meanwhile keeps its session. Called with mu held.
+func (s *Session) reapIfIdle(timer *time.Timer) {
+	if s.idleTimer != timer || len(s.clients) > 0 || s.exited {
+		return
+	}
+	log.Printf("Terminal session %s idle, ending it", s.ID)
+	s.cmd.Process.Kill()
+}
+
+// serve attaches a WebSocket to the session until either ends.
+func (s *Session) serve(conn *websocket.Conn) {
+	c, backlog, ok := s.attach(conn.RemoteAddr().String())
+	if !ok {
+		conn.WriteMessage(websocket.CloseMessage, []byte{})
+		return
+	}
+	defer s.detach(c)
+
+	hello, _ := jsonMessage(controlMessage{Type: "session", ID: s.ID})
+	go func() {
+		defer conn.Close()
+		if err := conn.WriteMessage(hello.kind, hello.data); err != nil {
+			return
+		}
+		if len(backlog) > 0 {
+			if err := conn.WriteMessage(websocket.BinaryMessage, backlog); err != nil {
+				return
+			}
+		}
+		for message := range c.out {
+			if err := conn.WriteMessage(message.kind, message.data); err != nil {
+				log.Printf("failed to write to websocket: %v", err)
+				return
+			}
+		}
+		conn.WriteMessage(websocket.CloseMessage, []byte{})
+	}()
+
+	// Read from websocket and write to tty
+	for {
+		msgType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if msgType == websocket.TextMessage {
+			var resizeMsg resizeMessage
+			if err := json.Unmarshal(message, &resizeMsg); err == nil && resizeMsg.Type == "resize" {
+				s.resize(resizeMsg.Cols, resizeMsg.Rows)
+				continue
+			}
+		}
+
+		if _, err := s.tty.Write(message); err != nil {
+			log.Printf("failed to write to pty: %v", err)
+			return
+		}
+	}
+}
+
+// ring keeps the last maxScrollback bytes written to it
+type ring struct {
+	buf   []byte
+	start int // Oldest byte once the buffer is full
+}
+
+func (r *ring) write(data []byte) {
+	if len(data) >= maxScrollback {
+		r.buf = append(r.buf[:0], data[len(data)-maxScrollback:]...)
+		r.start = 0
+		return
+	}
+	for len(data) > 0 {
+		if len(r.buf) < maxScrollback {
+			n := min(len(data), maxScrollback-len(r.buf))
+			r.buf = append(r.buf, data[:n]...)
+			data = data[n:]
+			continue
+		}
+		n := copy(r.buf[r.start:], data)
+		r.start = (r.start + n) % maxScrollback
+		data = data[n:]
+	}
+}
+
+// bytes returns the kept output in order, starting at a character boundary.
+func (r *ring) bytes() []byte {
+	out := make([]byte, 0, len(r.buf))
+	out = append(out, r.buf[r.start:]...)
+	out = append(out, r.buf[:r.start]...)
+	for len(out) > 0 && !utf8.RuneStart(out[0]) {
+		out = out[1:]
+	}
+	return out
+}
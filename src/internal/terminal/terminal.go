@@ -5,9 +5,7 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 
-	"github.com/creack/pty"
 	"github.com/gorilla/websocket"
 )
 
@@ -25,6 +23,9 @@ type resizeMessage struct {
 	Rows uint16 `json:"rows"`
 }
 
+// New returns the handler for /terminal. Each connection starts a new
+// session in the workspace given by ?root= (at ?cwd= inside it, running
+// ?profile=), or reattaches to a running one with ?session=<id>.
 func New() (http.Handler, error) {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/terminal" {
@@ -32,6 +33,14 @@ func New() (http.Handler, error) {
 			return
 		}
 
+		var session *Session
+		if id := r.URL.Query().Get("session"); id != "" {
+			if session = Lookup(id); session == nil {
+				http.Error(w, "terminal session not found", http.StatusNotFound)
+				return
+			}
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Printf("failed to upgrade websocket: %v", err)
@@ -39,72 +48,38 @@ func New() (http.Handler, error) {
 		}
 		defer conn.Close()
 
-		// Get shell from environment, fallback to common shells
-		shell := os.Getenv("SHELL")
-		if shell == "" {
-			shell = "/bin/bash"
-			if _, err := os.Stat("/bin/zsh"); err == nil {
-				shell = "/bin/zsh"
+		if session == nil {
+			query := r.URL.Query()
+			rootPath := query.Get("root")
+			if rootPath == "" || rootPath == "." {
+				if cwd, err := os.Getwd(); err == nil {
+					rootPath = cwd
+				} else {
+					rootPath = "."
+				}
 			}
-		}
-
-		log.Printf("Starting shell: %s", shell)
-		cmd := exec.Command(shell)
-		cmd.Env = os.Environ()
-
-		tty, err := pty.Start(cmd)
-		if err != nil {
-			log.Printf("failed to start pty: %v", err)
-			return
-		}
-		defer tty.Close()
-
-		log.Printf("PTY started successfully")
-
-		// Read from tty and write to websocket
-		go func() {
-			defer func() {
-				log.Printf("PTY read goroutine exiting")
-			}()
-
-		buf := make([]byte, 32*1024)
-		for {
-			n, err := tty.Read(buf)
+			session, err = Create(Options{
+				Root:    rootPath,
+				Cwd:     query.Get("cwd"),
+				Profile: query.Get("profile"),
+			})
 			if err != nil {
-				log.Printf("failed to read from pty: %v", err)
+				log.Printf("failed to start pty: %v", err)
+				if message, err := jsonMessage(controlMessage{Type: "error", Message: err.Error()}); err == nil {
+					conn.WriteMessage(message.kind, message.data)
+				}
 				conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
-				log.Printf("failed to write to websocket: %v", err)
-				return
-			}
+		} else {
+			log.Printf("Reattaching to terminal session %s", session.ID)
 		}
-		}()
-
-		// Read from websocket and write to tty
-		for {
-			msgType, message, err := conn.ReadMessage()
-			if err != nil {
-				log.Printf("failed to read from websocket: %v", err)
-				return
-			}
-
-			if msgType == websocket.TextMessage {
-				var resizeMsg resizeMessage
-				if err := json.Unmarshal(message, &resizeMsg); err == nil && resizeMsg.Type == "resize" {
-					// log.Printf("Resizing terminal to %dx%d", resizeMsg.Cols, resizeMsg.Rows)
-					if err := pty.Setsize(tty, &pty.Winsize{Rows: resizeMsg.Rows, Cols: resizeMsg.Cols}); err != nil {
-						log.Printf("failed to set pty size: %v", err)
-					}
-					continue
-				}
-			}
 
-			if _, err := tty.Write(message); err != nil {
-				log.Printf("failed to write to pty: %v", err)
-				return
-			}
-		}
+		session.serve(conn)
 	}), nil
 }
+
+func jsonMessage(v any) (wsMessage, error) {
+	data, err := json.Marshal(v)
+	return wsMessage{websocket.TextMessage, data}, err
+}
@@ -0,0 +1,346 @@
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrReadOnly is returned when writing to a path inside an archive.
+var ErrReadOnly = errors.New("archive contents are read-only")
+
+const (
+	// archiveSeparator splits an archive path from the entry inside it,
+	// as in /dist/app.zip!/lib/x.py
+	archiveSeparator = "!"
+
+	maxArchiveSize      = 256 * 1024 * 1024
+	archiveCacheEntries = 8
+)
+
+var archiveExtensions = []string{".zip", ".jar", ".war", ".whl", ".tar", ".tar.gz", ".tgz"}
+
+// isArchiveName reports whether name has an extension that can be browsed.
+func isArchiveName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitArchivePath splits a workspace path such as dist/app.zip!/lib/x.py
+// into the archive (dist/app.zip) and the entry inside it (lib/x.py).
+func splitArchivePath(p string) (archive, inner string, ok bool) {
+	i := strings.Index(p, archiveSeparator+"/")
+	if i < 0 {
+		if !strings.HasSuffix(p, archiveSeparator) {
+			return "", "", false
+		}
+		i = len(p) - len(archiveSeparator)
+	}
+	archive = p[:i]
+	if !isArchiveName(archive) {
+		return "", "", false
+	}
+	return archive, strings.Trim(p[i+len(archiveSeparator):], "/"), true
+}
+
+type archiveEntry struct {
+	info entryInfo
+	open func() (io.ReadCloser, error)
+}
+
+// archiveIndex is the parsed table of contents of one archive.
+type archiveIndex struct {
+	size     int64
+	modTime  time.Time
+	entries  map[string]*archiveEntry
+	children map[string][]string
+}
+
+func (idx *archiveIndex) add(name string, entry *archiveEntry) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return
+	}
+	if _, ok := idx.entries[name]; ok {
+		return
+	}
+	entry.info.name = path.Base(name)
+	idx.entries[name] = entry
+
+	// Archives don't always list parent directories, so create them
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	idx.children[parent] = append(idx.children[parent], name)
+	if parent != "" {
+		idx.add(parent, &archiveEntry{info: entryInfo{dir: true, modTime: entry.info.modTime}})
+	}
+}
+
+func (idx *archiveIndex) stat(inner string) (fs.FileInfo, error) {
+	if inner == "" {
+		return &entryInfo{dir: true, modTime: idx.modTime}, nil
+	}
+	entry, ok := idx.entries[inner]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: inner, Err: fs.ErrNotExist}
+	}
+	info := entry.info
+	return &info, nil
+}
+
+func (idx *archiveIndex) readDir(inner string) ([]fs.DirEntry, error) {
+	if inner != "" {
+		if entry, ok := idx.entries[inner]; !ok || !entry.info.dir {
+			return nil, &fs.PathError{Op: "readdir", Path: inner, Err: fs.ErrNotExist}
+		}
+	}
+	names := idx.children[inner]
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		info := idx.entries[name].info
+		entries = append(entries, fs.FileInfoToDirEntry(&info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (idx *archiveIndex) readFile(inner string) ([]byte, error) {
+	entry, ok := idx.entries[inner]
+	if !ok || entry.info.dir {
+		return nil, &fs.PathError{Op: "open", Path: inner, Err: fs.ErrNotExist}
+	}
+	rc, err := entry.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// Headers can understate the size, so cap what is actually inflated
+	data, err := io.ReadAll(io.LimitReader(rc, maxArchiveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchiveSize {
+		return nil, fmt.Errorf("%s: uncompressed size exceeds %d bytes", inner, maxArchiveSize)
+	}
+	return data, nil
+}
+
+// files returns every file entry in lexical order.
+func (idx *archiveIndex) files() []string {
+	var names []string
+	for name, entry := range idx.entries {
+		if !entry.info.dir {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseArchive(name string, data []byte) (*archiveIndex, error) {
+	idx := &archiveIndex{
+		entries:  make(map[string]*archiveEntry),
+		children: make(map[string][]string),
+	}
+
+	lower := strings.ToLower(name)
+	if !strings.HasSuffix(lower, ".tar") && !strings.HasSuffix(lower, ".tar.gz") && !strings.HasSuffix(lower, ".tgz") {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			idx.add(f.Name, &archiveEntry{
+				info: entryInfo{
+					size:    int64(f.UncompressedSize64),
+					modTime: f.Modified,
+					dir:     f.FileInfo().IsDir(),
+				},
+				open: f.Open,
+			})
+		}
+		return idx, nil
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if !strings.HasSuffix(lower, ".tar") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	var total int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			idx.add(header.Name, &archiveEntry{info: entryInfo{dir: true, modTime: header.ModTime}})
+		case tar.TypeReg:
+			// Tar has no random access, so file contents are kept in memory
+			total += header.Size
+			if total > maxArchiveSize {
+				return nil, fmt.Errorf("%s: uncompressed size exceeds %d bytes", name, maxArchiveSize)
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			idx.add(header.Name, &archiveEntry{
+				info: entryInfo{size: header.Size, modTime: header.ModTime},
+				open: func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(content)), nil
+				},
+			})
+		}
+	}
+	return idx, nil
+}
+
+// archiveCache keeps recently opened archives, revalidated by size and
+// modification time.
+var archiveCache = struct {
+	sync.Mutex
+	order   []string
+	indexes map[string]*archiveIndex
+}{indexes: make(map[string]*archiveIndex)}
+
+func openArchive(backend Backend, name string) (*archiveIndex, error) {
+	info, err := backend.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if info.Size() > maxArchiveSize {
+		return nil, fmt.Errorf("%s: archive larger than %d bytes", name, maxArchiveSize)
+	}
+
+	key := fmt.Sprintf("%p\x00%s", backend, name)
+	archiveCache.Lock()
+	idx, ok := archiveCache.indexes[key]
+	archiveCache.Unlock()
+	if ok && idx.size == info.Size() && idx.modTime.Equal(info.ModTime()) {
+		return idx, nil
+	}
+
+	data, err := backend.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	idx, err = parseArchive(name, data)
+	if err != nil {
+		return nil, err
+	}
+	idx.size = info.Size()
+	idx.modTime = info.ModTime()
+
+	archiveCache.Lock()
+	defer archiveCache.Unlock()
+	if _, ok := archiveCache.indexes[key]; !ok {
+		archiveCache.order = append(archiveCache.order, key)
+	}
+	archiveCache.indexes[key] = idx
+	for len(archiveCache.order) > archiveCacheEntries {
+		delete(archiveCache.indexes, archiveCache.order[0])
+		archiveCache.order = archiveCache.order[1:]
+	}
+	return idx, nil
+}
+
+// archiveDirectoryContents lists the entries of a directory inside an archive.
+func archiveDirectoryContents(backend Backend, archive, inner string) ([]*FileNode, error) {
+	idx, err := openArchive(backend, archive)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := idx.readDir(inner)
+	if err != nil {
+		return nil, err
+	}
+
+	// Folders first, then files
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IsDir() && !entries[j].IsDir()
+	})
+
+	nodes := make([]*FileNode, 0, len(entries))
+	for _, entry := range entries {
+		entryInner := joinName(inner, entry.Name())
+		node := &FileNode{
+			Name: entry.Name(),
+			Type: "file",
+			Path: "/" + archive + archiveSeparator + "/" + entryInner,
+		}
+		if entry.IsDir() {
+			node.Type = "folder"
+			node.HasMore = len(idx.children[entryInner]) > 0
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// copyFromArchive extracts a file or directory from an archive into the
+// workspace.
+func copyFromArchive(backend Backend, archive, inner, dst string) error {
+	idx, err := openArchive(backend, archive)
+	if err != nil {
+		return err
+	}
+	info, err := idx.stat(inner)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		content, err := idx.readFile(inner)
+		if err != nil {
+			return err
+		}
+		if err := backend.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return backend.WriteFile(dst, content, 0644)
+	}
+
+	if err := backend.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, child := range idx.children[inner] {
+		if err := copyFromArchive(backend, archive, child, joinName(dst, path.Base(child))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
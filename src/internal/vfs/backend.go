@@ -0,0 +1,213 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend is the storage a workspace lives on. Names are slash-separated and
+// relative to the workspace root; "" names the root itself.
+type Backend interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	RemoveAll(name string) error
+	Rename(oldName, newName string) error
+}
+
+// LocalBackend serves a workspace from the local filesystem.
+type LocalBackend struct {
+	Root string
+}
+
+// Path returns the OS path for a workspace-relative name.
+func (b *LocalBackend) Path(name string) string {
+	return filepath.Join(b.Root, filepath.FromSlash(name))
+}
+
+func (b *LocalBackend) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(b.Path(name))
+}
+
+func (b *LocalBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(b.Path(name))
+}
+
+func (b *LocalBackend) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(b.Path(name))
+}
+
+func (b *LocalBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(b.Path(name), data, perm)
+}
+
+func (b *LocalBackend) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(b.Path(name), perm)
+}
+
+func (b *LocalBackend) RemoveAll(name string) error {
+	return os.RemoveAll(b.Path(name))
+}
+
+func (b *LocalBackend) Rename(oldName, newName string) error {
+	return os.Rename(b.Path(oldName), b.Path(newName))
+}
+
+var (
+	workspacesMu sync.Mutex
+	workspaces   = map[string]Backend{}
+)
+
+// Open returns the backend for a workspace root. Roots of the form
+// user@host:/path or sftp://user@host[:port]/path are served over SFTP,
+// s3://bucket/prefix from an S3-compatible object store, and anything else
+// is a local directory. Backends are cached per root.
+func Open(root string) (Backend, error) {
+	root = CleanRoot(root)
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+
+	if b, ok := workspaces[root]; ok {
+		return b, nil
+	}
+
+	var b Backend
+	if target, ok := parseSFTPRoot(root); ok {
+		sb, err := newSFTPBackend(target)
+		if err != nil {
+			return nil, err
+		}
+		b = sb
+	} else if isS3Root(root) {
+		sb, err := newS3Backend(root)
+		if err != nil {
+			return nil, err
+		}
+		b = sb
+	} else {
+		b = &LocalBackend{Root: root}
+	}
+	workspaces[root] = b
+	return b, nil
+}
+
+// CleanRoot normalizes a workspace root so every spelling of one workspace
+// is the same key: local roots are made absolute and cleaned, remote roots
+// are URLs or host:path forms and are left as is.
+func CleanRoot(root string) string {
+	if !IsLocal(root) {
+		return root
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		return abs
+	}
+	return filepath.Clean(root)
+}
+
+// Trusted reports whether NANO_IDE_HOOKS trusts a workspace to run its own
+// commands (hooks, terminal profiles and env files): "true" for every
+// workspace, or a comma-separated list of workspace roots. Opening a folder
+// must not be enough to run code it contains.
+func Trusted(root string) bool {
+	value := os.Getenv("NANO_IDE_HOOKS")
+	if value == "1" || value == "true" {
+		return true
+	}
+	root = CleanRoot(root)
+	for _, trusted := range strings.Split(value, ",") {
+		if trusted = strings.TrimSpace(trusted); trusted != "" && CleanRoot(trusted) == root {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLocal reports whether root is a directory on the local filesystem.
+func IsLocal(root string) bool {
+	_, ok := parseSFTPRoot(root)
+	return !ok && !isS3Root(root)
+}
+
+// LocalPath returns the OS path for a workspace-relative name when root is
+// local.
+func LocalPath(root, name string) (string, bool) {
+	if !IsLocal(root) {
+		return "", false
+	}
+	return filepath.Join(filepath.Clean(root), filepath.FromSlash(name)), true
+}
+
+// walkFunc is called for every entry below the walked directory. Returning
+// fs.SkipDir skips a directory and fs.SkipAll stops the walk.
+type walkFunc func(name string, entry fs.DirEntry) error
+
+// walkBackend walks the tree rooted at dir in lexical order.
+func walkBackend(b Backend, dir string, fn walkFunc) error {
+	err := walkBackendDir(b, dir, fn)
+	if errors.Is(err, fs.SkipAll) || errors.Is(err, fs.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+func walkBackendDir(b Backend, dir string, fn walkFunc) error {
+	entries, err := b.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		name := joinName(dir, entry.Name())
+		if err := fn(name, entry); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				if entry.IsDir() {
+					continue
+				}
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if err := walkBackendDir(b, name, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entryInfo describes entries of backends that have no native FileInfo.
+type entryInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *entryInfo) Name() string       { return i.name }
+func (i *entryInfo) Size() int64        { return i.size }
+func (i *entryInfo) ModTime() time.Time { return i.modTime }
+func (i *entryInfo) IsDir() bool        { return i.dir }
+func (i *entryInfo) Sys() any           { return nil }
+
+func (i *entryInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// joinName joins workspace-relative names.
+func joinName(dir, name string) string {
+	if dir == "" || dir == "." {
+		return name
+	}
+	return path.Join(dir, name)
+}
@@ -0,0 +1,167 @@
+package vfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// ErrChangesetNotFound is returned for unknown or evicted changesets.
+var ErrChangesetNotFound = errors.New("changeset not found")
+
+const (
+	// maxChangesets and maxChangesetBytes bound the replace history; the
+	// oldest changesets are dropped first
+	maxChangesets     = 20
+	maxChangesetBytes = 64 * 1024 * 1024
+)
+
+// ChangesetFile records one file touched by a replace.
+type ChangesetFile struct {
+	Path         string `json:"path"`
+	OriginalHash string `json:"originalHash"`
+	ResultHash   string `json:"resultHash"`
+	Replacements int    `json:"replacements"`
+
+	original []byte
+}
+
+// Changeset is one recorded ReplaceWorkspace run.
+type Changeset struct {
+	ID        string          `json:"id"`
+	Root      string          `json:"root"`
+	Query     string          `json:"query"`
+	Replace   string          `json:"replace"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Reverted  bool            `json:"reverted"`
+	Files     []ChangesetFile `json:"files"`
+}
+
+// RevertResult is returned after reverting a changeset.
+type RevertResult struct {
+	Files     int      `json:"files"`
+	Paths     []string `json:"paths"`
+	Conflicts []string `json:"conflicts,omitempty"` // Files edited since the replace, left untouched
+}
+
+// changesets is the in-memory replace history, oldest first.
+var changesets struct {
+	sync.Mutex
+	list  []*Changeset
+	bytes int
+}
+
+// recordChangeset stores the original contents of the files a replace
+// wrote and returns the new changeset's ID.
+func recordChangeset(rootPath string, options SearchOptions, files []ChangesetFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	cs := &Changeset{
+		ID:        hex.EncodeToString(id),
+		Root:      rootPath,
+		Query:     options.Query,
+		Replace:   options.Replace,
+		CreatedAt: time.Now(),
+		Files:     files,
+	}
+
+	changesets.Lock()
+	defer changesets.Unlock()
+	changesets.list = append(changesets.list, cs)
+	changesets.bytes += changesetSize(cs)
+	for len(changesets.list) > 1 && (len(changesets.list) > maxChangesets || changesets.bytes > maxChangesetBytes) {
+		changesets.bytes -= changesetSize(changesets.list[0])
+		changesets.list = changesets.list[1:]
+	}
+	return cs.ID
+}
+
+func newChangesetFile(name string, original, replaced []byte, replacements int) ChangesetFile {
+	return ChangesetFile{
+		Path:         "/" + name,
+		OriginalHash: ContentHash(original),
+		ResultHash:   ContentHash(replaced),
+		Replacements: replacements,
+		original:     original,
+	}
+}
+
+func changesetSize(cs *Changeset) int {
+	size := 0
+	for _, file := range cs.Files {
+		size += len(file.original)
+	}
+	return size
+}
+
+// ListChangesets returns the recorded replaces for a workspace, newest first.
+func ListChangesets(rootPath string) []Changeset {
+	changesets.Lock()
+	defer changesets.Unlock()
+	list := []Changeset{}
+	for i := len(changesets.list) - 1; i >= 0; i-- {
+		if cs := changesets.list[i]; cs.Root == rootPath {
+			list = append(list, *cs)
+		}
+	}
+	return list
+}
+
+// RevertChangeset restores the files of a recorded replace. Files whose
+// content no longer matches what the replace wrote are reported as
+// conflicts and left alone, unless force is set.
+func RevertChangeset(rootPath, id string, force bool) (RevertResult, error) {
+	var result RevertResult
+
+	changesets.Lock()
+	var cs *Changeset
+	for _, candidate := range changesets.list {
+		if candidate.ID == id && candidate.Root == rootPath {
+			cs = candidate
+		}
+	}
+	changesets.Unlock()
+	if cs == nil {
+		return result, ErrChangesetNotFound
+	}
+
+	backend, err := Open(rootPath)
+	if err != nil {
+		return result, err
+	}
+
+	for _, file := range cs.Files {
+		name := file.Path[1:]
+		if !force {
+			current, err := backend.ReadFile(name)
+			if err == nil && ContentHash(current) == file.OriginalHash {
+				continue // Already reverted
+			}
+			if err != nil || ContentHash(current) != file.ResultHash {
+				result.Conflicts = append(result.Conflicts, file.Path)
+				continue
+			}
+		}
+
+		perm := fs.FileMode(0644)
+		if info, err := backend.Stat(name); err == nil {
+			perm = info.Mode()
+		}
+		if err := backend.WriteFile(name, file.original, perm); err != nil {
+			return result, err
+		}
+		result.Files++
+		result.Paths = append(result.Paths, file.Path)
+	}
+
+	changesets.Lock()
+	cs.Reverted = len(result.Conflicts) == 0
+	changesets.Unlock()
+	return result, nil
+}
@@ -0,0 +1,131 @@
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// encodingSample is how much of a file the UTF-16 detection looks at
+const encodingSample = 4096
+
+// textFile is a file's content decoded to UTF-8 for searching, together
+// with what's needed to write replaced text back in the original encoding.
+type textFile struct {
+	raw      []byte
+	text     []byte
+	encoding string // Canonical name, empty for UTF-8
+	enc      encoding.Encoding
+	bom      []byte
+}
+
+// encode converts replaced text back to the file's encoding.
+func (f *textFile) encode(text []byte) ([]byte, error) {
+	if f.enc == nil {
+		return text, nil
+	}
+	out, err := f.enc.NewEncoder().Bytes(text)
+	if err != nil {
+		return nil, fmt.Errorf("replacement can't be written as %s: %w", f.encoding, err)
+	}
+	return append(append([]byte(nil), f.bom...), out...), nil
+}
+
+// validateSearchEncoding reports unknown encoding names.
+func validateSearchEncoding(name string) error {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		return nil
+	}
+	if _, err := htmlindex.Get(name); err != nil {
+		return fmt.Errorf("unknown encoding %q", name)
+	}
+	return nil
+}
+
+// decodeTextFile decodes content for searching. By default only UTF-8 text
+// is accepted. With "auto", files with a byte order mark or UTF-16 content
+// are decoded accordingly and other non-UTF-8 text is read as
+// Windows-1252; any other name is the encoding assumed for files that
+// aren't valid UTF-8. It reports false for binary files.
+func decodeTextFile(content []byte, encodingName string) (*textFile, bool) {
+	file := &textFile{raw: content, text: content}
+	if encodingName == "" {
+		return file, utf8.Valid(content) && !hasNulByte(content)
+	}
+
+	name, bom := byteOrderMark(content)
+	if name == "" {
+		if utf8.Valid(content) && !hasNulByte(content) {
+			return file, true
+		}
+		name = encodingName
+		if strings.EqualFold(name, "auto") {
+			if name = detectEncoding(content); name == "" {
+				return nil, false
+			}
+		}
+	}
+	if name == "utf-8" {
+		// The BOM stays part of the text so replacements keep it
+		return file, utf8.Valid(content) && !hasNulByte(content)
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, false
+	}
+	text, err := enc.NewDecoder().Bytes(content[len(bom):])
+	if err != nil || hasNulByte(text) {
+		return nil, false
+	}
+	file.text = text
+	file.enc = enc
+	file.bom = bom
+	file.encoding, _ = htmlindex.Name(enc)
+	return file, true
+}
+
+// byteOrderMark returns the encoding named by a leading byte order mark.
+func byteOrderMark(content []byte) (string, []byte) {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", content[:3]
+	case bytes.HasPrefix(content, []byte{0xFF, 0xFE}):
+		return "utf-16le", content[:2]
+	case bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+		return "utf-16be", content[:2]
+	}
+	return "", nil
+}
+
+// detectEncoding guesses the encoding of content that isn't valid UTF-8.
+// Mostly-ASCII UTF-16 shows up as NUL bytes at every other position; other
+// files with NUL bytes are binary, and the rest are read as Windows-1252,
+// which also covers Latin-1.
+func detectEncoding(content []byte) string {
+	sample := content[:min(len(content), encodingSample)&^1]
+	var evenZeros, oddZeros int
+	for i := 0; i < len(sample); i += 2 {
+		if sample[i] == 0 {
+			evenZeros++
+		}
+		if sample[i+1] == 0 {
+			oddZeros++
+		}
+	}
+	pairs := len(sample) / 2
+	switch {
+	case pairs > 0 && oddZeros*10 > pairs*4 && evenZeros*20 < pairs:
+		return "utf-16le"
+	case pairs > 0 && evenZeros*10 > pairs*4 && oddZeros*20 < pairs:
+		return "utf-16be"
+	case hasNulByte(content):
+		return ""
+	}
+	return "windows-1252"
+}
@@ -0,0 +1,231 @@
+package vfs
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// searchFileTypes maps the type: shorthands accepted in include and exclude
+// filters to file name patterns.
+var searchFileTypes = map[string][]string{
+	"c":        {"*.c", "*.h"},
+	"cpp":      {"*.cpp", "*.cc", "*.cxx", "*.hpp", "*.hh", "*.hxx", "*.h"},
+	"cs":       {"*.cs", "*.csx"},
+	"css":      {"*.css", "*.scss", "*.sass", "*.less"},
+	"docker":   {"Dockerfile", "*.dockerfile", "Dockerfile.*"},
+	"go":       {"*.go"},
+	"html":     {"*.html", "*.htm", "*.xhtml"},
+	"java":     {"*.java"},
+	"js":       {"*.js", "*.mjs", "*.cjs", "*.jsx"},
+	"json":     {"*.json", "*.jsonc"},
+	"kotlin":   {"*.kt", "*.kts"},
+	"lua":      {"*.lua"},
+	"make":     {"Makefile", "makefile", "GNUmakefile", "*.mk"},
+	"markdown": {"*.md", "*.markdown", "*.mdx"},
+	"md":       {"*.md", "*.markdown", "*.mdx"},
+	"php":      {"*.php"},
+	"proto":    {"*.proto"},
+	"py":       {"*.py", "*.pyi", "*.pyw"},
+	"python":   {"*.py", "*.pyi", "*.pyw"},
+	"rb":       {"*.rb", "Gemfile", "Rakefile", "*.gemspec"},
+	"rs":       {"*.rs"},
+	"rust":     {"*.rs"},
+	"sh":       {"*.sh", "*.bash", "*.zsh", ".bashrc", ".zshrc", ".profile"},
+	"sql":      {"*.sql"},
+	"swift":    {"*.swift"},
+	"toml":     {"*.toml"},
+	"ts":       {"*.ts", "*.tsx", "*.mts", "*.cts"},
+	"txt":      {"*.txt"},
+	"xml":      {"*.xml", "*.xsd", "*.xsl", "*.svg"},
+	"yaml":     {"*.yaml", "*.yml"},
+	"yml":      {"*.yaml", "*.yml"},
+}
+
+// maxFilterCache bounds the compiled filters kept between searches
+const maxFilterCache = 256
+
+var filterCache struct {
+	sync.Mutex
+	patterns map[string][]string
+}
+
+func matchesSearchFilters(relPath string, options SearchOptions) bool {
+	path := strings.TrimPrefix(relPath, "/")
+	if options.Include != "" && !pathMatchesFilter(path, options.Include) {
+		return false
+	}
+	if options.Exclude != "" && pathMatchesFilter(path, options.Exclude) {
+		return false
+	}
+	return true
+}
+
+// validateSearchOptions reports malformed globs, unknown file types and
+// unknown encodings.
+func validateSearchOptions(options SearchOptions) error {
+	if _, err := compileSearchFilter(options.Include); err != nil {
+		return fmt.Errorf("include: %w", err)
+	}
+	if _, err := compileSearchFilter(options.Exclude); err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+	return validateSearchEncoding(options.Encoding)
+}
+
+func pathMatchesFilter(path, filter string) bool {
+	filterCache.Lock()
+	patterns, ok := filterCache.patterns[filter]
+	filterCache.Unlock()
+	if !ok {
+		var err error
+		if patterns, err = compileSearchFilter(filter); err != nil {
+			return false
+		}
+		filterCache.Lock()
+		if filterCache.patterns == nil || len(filterCache.patterns) >= maxFilterCache {
+			filterCache.patterns = make(map[string][]string)
+		}
+		filterCache.patterns[filter] = patterns
+		filterCache.Unlock()
+	}
+
+	for _, pattern := range patterns {
+		if doublestar.MatchUnvalidated(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSearchFilter turns a comma-separated filter into doublestar
+// patterns matched against workspace-relative paths. Patterns without a
+// slash match at any depth, a pattern naming a directory also matches
+// everything inside it, and type:<name> expands to the patterns of a file
+// type.
+func compileSearchFilter(filter string) ([]string, error) {
+	var patterns []string
+	for _, token := range splitFilterTokens(filter) {
+		if name, ok := strings.CutPrefix(token, "type:"); ok {
+			types, ok := searchFileTypes[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown file type %q", name)
+			}
+			for _, pattern := range types {
+				patterns = append(patterns, "**/"+pattern)
+			}
+			continue
+		}
+
+		token = strings.Trim(strings.ReplaceAll(token, "\\", "/"), "/")
+		token = strings.TrimPrefix(token, "./")
+		if token == "" {
+			continue
+		}
+		if !doublestar.ValidatePattern(token) {
+			return nil, fmt.Errorf("invalid pattern %q", token)
+		}
+		if !strings.Contains(token, "/") {
+			token = "**/" + token
+		}
+		patterns = append(patterns, token, token+"/**")
+	}
+	return patterns, nil
+}
+
+// splitFilterTokens splits a filter on commas outside of braces, so
+// "*.{ts,tsx}, docs" is two tokens.
+func splitFilterTokens(filter string) []string {
+	var tokens []string
+	depth, start := 0, 0
+	for i := 0; i < len(filter); i++ {
+		switch filter[i] {
+		case '{':
+			depth++
+		case '}':
+			depth = max(depth-1, 0)
+		case ',':
+			if depth == 0 {
+				tokens = append(tokens, filter[start:i])
+				start = i + 1
+			}
+		}
+	}
+	tokens = append(tokens, filter[start:])
+
+	kept := tokens[:0]
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			kept = append(kept, token)
+		}
+	}
+	return kept
+}
+
+// walkSearchTree walks the files a search may read. Symlinks are skipped
+// unless options.FollowSymlinks is set; then linked files are read through
+// and, on local disks, linked directories are descended once each.
+func walkSearchTree(backend Backend, options SearchOptions, fn walkFunc) error {
+	if !options.FollowSymlinks {
+		return walkBackend(backend, "", func(name string, entry fs.DirEntry) error {
+			if entry.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			return fn(name, entry)
+		})
+	}
+
+	local, isLocal := backend.(*LocalBackend)
+	visited := map[string]bool{}
+	if isLocal {
+		if real, err := filepath.EvalSymlinks(local.Path("")); err == nil {
+			visited[real] = true
+		}
+	}
+
+	var follow walkFunc
+	follow = func(name string, entry fs.DirEntry) error {
+		if entry.Type()&fs.ModeSymlink == 0 {
+			return fn(name, entry)
+		}
+		info, err := backend.Stat(name)
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			return fn(name, fs.FileInfoToDirEntry(info))
+		}
+		if !isLocal {
+			return nil
+		}
+
+		// Stop at cycles and at trees reached another way
+		real, err := filepath.EvalSymlinks(local.Path(name))
+		if err != nil || isWithinVisited(real, visited) {
+			return nil
+		}
+		visited[real] = true
+		if err := fn(name, fs.FileInfoToDirEntry(info)); err != nil {
+			if err == fs.SkipDir {
+				return nil
+			}
+			return err
+		}
+		return walkBackendDir(backend, name, follow)
+	}
+	return walkBackend(backend, "", follow)
+}
+
+// isWithinVisited reports whether dir is, or lies below, a walked tree.
+func isWithinVisited(dir string, visited map[string]bool) bool {
+	for root := range visited {
+		if dir == root || strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
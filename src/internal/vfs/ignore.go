@@ -0,0 +1,262 @@
+package vfs
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	gitIgnoreFile       = ".gitignore"
+	workspaceIgnoreFile = ".nanoideignore"
+)
+
+// defaultIgnorePatterns are hidden in every workspace. Project build output
+// such as build/, dist/ or target/ is left to the project's .gitignore.
+// NANO_IDE_IGNORE replaces them with a comma-separated list of patterns.
+var defaultIgnorePatterns = []string{
+	".git/",
+	"node_modules/",
+	".next/",
+	".nuxt/",
+	".pnpm/",
+	".vscode/",
+	".idea/",
+	"__pycache__/",
+	".venv/",
+	"venv/",
+	".pipenv/",
+	".tox/",
+	".pytest_cache/",
+	".ruff_cache/",
+	".mypy_cache/",
+	".cache/",
+}
+
+// ignoreRule is one compiled line of an ignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Ignorer decides which workspace paths are hidden from the tree, search and
+// the file watcher. It merges the default patterns with .gitignore files at
+// every level (including negation) and a .nanoideignore at the workspace
+// root. Ignore files are read lazily and cached until Reset.
+type Ignorer struct {
+	backend  Backend
+	defaults []ignoreRule
+
+	mu    sync.Mutex
+	rules map[string][]ignoreRule // rules loaded from each directory
+	dirs  map[string]bool         // cached verdicts for directories
+}
+
+// NewIgnorer returns the ignore matcher for a workspace. Extra patterns use
+// gitignore syntax and are applied after the defaults.
+func NewIgnorer(rootPath string, extra ...string) (*Ignorer, error) {
+	backend, err := Open(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	return newIgnorer(backend, extra), nil
+}
+
+func newIgnorer(backend Backend, extra []string) *Ignorer {
+	patterns := defaultIgnorePatterns
+	if env, ok := os.LookupEnv("NANO_IDE_IGNORE"); ok {
+		patterns = strings.Split(env, ",")
+	}
+	ig := &Ignorer{backend: backend}
+	ig.defaults = parseIgnoreRules(append(append([]string{}, patterns...), extra...))
+	ig.Reset()
+	return ig
+}
+
+// Reset drops cached ignore files so changes to them are picked up.
+func (ig *Ignorer) Reset() {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	ig.rules = make(map[string][]ignoreRule)
+	ig.dirs = make(map[string]bool)
+}
+
+// Ignored reports whether the workspace-relative name is excluded, either
+// directly or because one of its parent directories is.
+func (ig *Ignorer) Ignored(name string, isDir bool) bool {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return false
+	}
+
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+
+	if parent := path.Dir(name); parent != "." && ig.dirIgnored(parent) {
+		return true
+	}
+	if isDir {
+		return ig.dirIgnored(name)
+	}
+	return ig.matches(name, false)
+}
+
+func (ig *Ignorer) dirIgnored(name string) bool {
+	if ignored, ok := ig.dirs[name]; ok {
+		return ignored
+	}
+	ignored := false
+	if parent := path.Dir(name); parent != "." {
+		ignored = ig.dirIgnored(parent)
+	}
+	if !ignored {
+		ignored = ig.matches(name, true)
+	}
+	ig.dirs[name] = ignored
+	return ignored
+}
+
+// matches applies every rule that can see name, in increasing precedence:
+// defaults, then ignore files from the root down to name's directory. The
+// last matching rule wins.
+func (ig *Ignorer) matches(name string, isDir bool) bool {
+	ignored := matchRules(ig.defaults, name, isDir, false)
+
+	dir := ""
+	for {
+		rel := name
+		if dir != "" {
+			rel = strings.TrimPrefix(name, dir+"/")
+		}
+		ignored = matchRules(ig.loadRules(dir), rel, isDir, ignored)
+
+		next := strings.Index(rel, "/")
+		if next < 0 {
+			return ignored
+		}
+		dir = joinName(dir, rel[:next])
+	}
+}
+
+func matchRules(rules []ignoreRule, rel string, isDir, ignored bool) bool {
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (ig *Ignorer) loadRules(dir string) []ignoreRule {
+	if rules, ok := ig.rules[dir]; ok {
+		return rules
+	}
+
+	files := []string{gitIgnoreFile}
+	if dir == "" {
+		files = append(files, workspaceIgnoreFile)
+	}
+	var rules []ignoreRule
+	for _, file := range files {
+		content, err := ig.backend.ReadFile(joinName(dir, file))
+		if err != nil {
+			continue
+		}
+		rules = append(rules, parseIgnoreRules(strings.Split(string(content), "\n"))...)
+	}
+	ig.rules[dir] = rules
+	return rules
+}
+
+// parseIgnoreRules compiles gitignore-syntax lines, skipping invalid ones.
+func parseIgnoreRules(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// A slash anywhere but the end anchors the pattern to its directory
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		prefix := "^"
+		if !anchored {
+			prefix = "^(?:.*/)?"
+		}
+		re, err := regexp.Compile(prefix + ignoreGlobToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignoreGlobToRegexp translates gitignore wildcards: "*" and "?" stay inside
+// one path segment, "**" spans segments and [...] is a character class.
+func ignoreGlobToRegexp(pattern string) string {
+	var builder strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			builder.WriteString(`(?:.*/)?`)
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			builder.WriteString(`/.*`)
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			builder.WriteString(`.*`)
+			i++
+		case c == '*':
+			builder.WriteString(`[^/]*`)
+		case c == '?':
+			builder.WriteString(`[^/]`)
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			builder.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case c == '[':
+			end := strings.Index(pattern[i+1:], "]")
+			if end < 0 {
+				builder.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return builder.String()
+}
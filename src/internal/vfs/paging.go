@@ -0,0 +1,140 @@
+package vfs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// ErrInvalidCursor is returned for cursors that weren't produced by a listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// listCursor records where a page ended. The name is preferred over the
+// offset so entries created or deleted before it don't shift the next page.
+type listCursor struct {
+	Offset int    `json:"o"`
+	Name   string `json:"n"`
+}
+
+func encodeCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.Offset < 0 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// pageEntries returns the MaxFiles entries following options.Cursor and the
+// cursor for the page after them.
+func pageEntries(entries []fs.DirEntry, options TreeOptions) ([]fs.DirEntry, string, error) {
+	start := 0
+	if options.Cursor != "" {
+		c, err := decodeCursor(options.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = min(c.Offset, len(entries))
+		for i, entry := range entries {
+			if entry.Name() == c.Name {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	end := len(entries)
+	if options.MaxFiles > 0 {
+		end = min(start+options.MaxFiles, len(entries))
+	}
+	page := entries[start:end]
+	if end == len(entries) || len(page) == 0 {
+		return page, "", nil
+	}
+	return page, encodeCursor(listCursor{Offset: end, Name: page[len(page)-1].Name()}), nil
+}
+
+// sortEntries orders a directory listing. Modification time and size sort
+// newest and largest first; ties fall back to the name.
+func sortEntries(entries []fs.DirEntry, options TreeOptions) {
+	var infos map[string]fs.FileInfo
+	if options.Sort == "mtime" || options.Sort == "size" {
+		infos = make(map[string]fs.FileInfo, len(entries))
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil {
+				infos[entry.Name()] = info
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !options.MixFolders && a.IsDir() != b.IsDir() {
+			return a.IsDir()
+		}
+
+		switch options.Sort {
+		case "natural":
+			return naturalLess(a.Name(), b.Name())
+		case "mtime":
+			ai, bi := infos[a.Name()], infos[b.Name()]
+			if ai != nil && bi != nil && !ai.ModTime().Equal(bi.ModTime()) {
+				return ai.ModTime().After(bi.ModTime())
+			}
+		case "size":
+			ai, bi := infos[a.Name()], infos[b.Name()]
+			if ai != nil && bi != nil && ai.Size() != bi.Size() {
+				return ai.Size() > bi.Size()
+			}
+		}
+		return a.Name() < b.Name()
+	})
+}
+
+// naturalLess compares names treating digit runs as numbers, so file2
+// sorts before file10.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, restA := splitDigits(a)
+			nb, restB := splitDigits(b)
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// splitDigits returns the leading digit run of s without leading zeros.
+func splitDigits(s string) (digits, rest string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	digits = s[:i]
+	for len(digits) > 1 && digits[0] == '0' {
+		digits = digits[1:]
+	}
+	return digits, s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,450 @@
+package vfs
+
+import (
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FindMatch is one quick-open result. Positions are the byte offsets in Path
+// of the matched query characters, for highlighting.
+type FindMatch struct {
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Score     int    `json:"score"`
+	Positions []int  `json:"positions"`
+}
+
+// PathIndex is an in-memory list of every non-ignored file in a workspace,
+// used for fuzzy file finding. It is built once and then kept current with
+// Update as the watcher reports batches of changes.
+type PathIndex struct {
+	backend Backend
+	ignore  *Ignorer
+
+	mu      sync.RWMutex
+	files   map[string]struct{}
+	sorted  []string // snapshot of files, rebuilt after changes
+	builtAt time.Time
+}
+
+var (
+	pathIndexesMu sync.Mutex
+	pathIndexes   = map[string]*PathIndex{}
+)
+
+// GetPathIndex returns the shared path index for a workspace, building it on
+// first use. The boolean reports whether the index was newly built.
+func GetPathIndex(rootPath string) (*PathIndex, bool, error) {
+	pathIndexesMu.Lock()
+	defer pathIndexesMu.Unlock()
+
+	if idx, ok := pathIndexes[rootPath]; ok {
+		return idx, false, nil
+	}
+
+	backend, err := Open(rootPath)
+	if err != nil {
+		return nil, false, err
+	}
+	idx := &PathIndex{backend: backend, ignore: newIgnorer(backend, nil)}
+	if err := idx.Rebuild(); err != nil {
+		return nil, false, err
+	}
+	pathIndexes[rootPath] = idx
+	return idx, true, nil
+}
+
+// UpdatePathIndex applies a batch of changes reported by the file watcher
+// to the workspace's path index, if it has one.
+func UpdatePathIndex(rootPath string, names []string) error {
+	pathIndexesMu.Lock()
+	idx := pathIndexes[rootPath]
+	pathIndexesMu.Unlock()
+	if idx == nil {
+		return nil
+	}
+	return idx.Update(names)
+}
+
+// RebuildPathIndex rebuilds the workspace's path index, if it has one, after
+// the file watcher lost track of changes.
+func RebuildPathIndex(rootPath string) error {
+	pathIndexesMu.Lock()
+	idx := pathIndexes[rootPath]
+	pathIndexesMu.Unlock()
+	if idx == nil {
+		return nil
+	}
+	return idx.Rebuild()
+}
+
+// Rebuild walks the whole workspace again, re-reading ignore files.
+func (idx *PathIndex) Rebuild() error {
+	idx.ignore.Reset()
+	files := make(map[string]struct{})
+	err := idx.walk("", files)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	idx.files = files
+	idx.sorted = nil
+	idx.builtAt = time.Now()
+	idx.mu.Unlock()
+	return nil
+}
+
+// BuiltAt returns when the index was last fully rebuilt.
+func (idx *PathIndex) BuiltAt() time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.builtAt
+}
+
+// Len returns the number of indexed files.
+func (idx *PathIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.files)
+}
+
+func (idx *PathIndex) walk(dir string, files map[string]struct{}) error {
+	return walkBackend(idx.backend, dir, func(name string, entry fs.DirEntry) error {
+		if idx.ignore.Ignored(name, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			files[name] = struct{}{}
+		}
+		return nil
+	})
+}
+
+// Update re-examines workspace-relative paths after a batch of changes: a
+// removed path drops it and everything below it, a new directory is walked
+// and a file is added unless ignored. A changed ignore file triggers a full
+// rebuild instead.
+func (idx *PathIndex) Update(names []string) error {
+	for _, name := range names {
+		base := path.Base(name)
+		if base == gitIgnoreFile || base == workspaceIgnoreFile {
+			return idx.Rebuild()
+		}
+	}
+
+	var removed []string
+	added := make(map[string]struct{})
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		info, err := idx.backend.Stat(name)
+		if err != nil || idx.ignore.Ignored(name, info.IsDir()) {
+			removed = append(removed, name)
+			continue
+		}
+		if !info.IsDir() {
+			added[name] = struct{}{}
+			continue
+		}
+		if err := idx.walk(name, added); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		idx.remove(removed)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for file := range added {
+		if _, ok := idx.files[file]; !ok {
+			idx.files[file] = struct{}{}
+			idx.sorted = nil
+		}
+	}
+	return nil
+}
+
+// remove drops files and everything below directories. Files below a
+// directory are found by binary search in the sorted snapshot, so a batch
+// costs one sort rather than a scan of the index per removed path.
+func (idx *PathIndex) remove(names []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	sorted := idx.sortedLocked()
+	for _, name := range names {
+		if _, ok := idx.files[name]; ok {
+			delete(idx.files, name)
+			idx.sorted = nil
+			continue
+		}
+		prefix := name + "/"
+		for i := sort.SearchStrings(sorted, prefix); i < len(sorted) && strings.HasPrefix(sorted[i], prefix); i++ {
+			delete(idx.files, sorted[i])
+			idx.sorted = nil
+		}
+	}
+}
+
+// snapshot returns the indexed files in lexical order. The slice is shared
+// and must not be modified.
+func (idx *PathIndex) snapshot() []string {
+	idx.mu.RLock()
+	sorted := idx.sorted
+	idx.mu.RUnlock()
+	if sorted != nil {
+		return sorted
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.sortedLocked()
+}
+
+// sortedLocked returns the snapshot, building it if needed. The caller holds
+// the write lock.
+func (idx *PathIndex) sortedLocked() []string {
+	if idx.sorted == nil {
+		idx.sorted = make([]string, 0, len(idx.files))
+		for file := range idx.files {
+			idx.sorted = append(idx.sorted, file)
+		}
+		sort.Strings(idx.sorted)
+	}
+	return idx.sorted
+}
+
+// Find returns up to limit files matching query, best first. Matching is
+// fzf-style: query characters must appear in order, and matches on word
+// boundaries, consecutive runs and the file name score higher. The query is
+// case-insensitive unless it contains an upper-case letter. Files are scored
+// in parallel chunks, each keeping only its best limit matches.
+func (idx *PathIndex) Find(query string, limit int) []FindMatch {
+	query = strings.ReplaceAll(query, " ", "")
+	if query == "" {
+		return []FindMatch{}
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	caseSensitive := strings.ToLower(query) != query
+	files := idx.snapshot()
+
+	workers := runtime.GOMAXPROCS(0)
+	chunk := (len(files) + workers - 1) / workers
+	if chunk < 1024 {
+		chunk = 1024
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		matches []FindMatch
+	)
+	for start := 0; start < len(files); start += chunk {
+		end := min(start+chunk, len(files))
+		wg.Add(1)
+		go func(files []string) {
+			defer wg.Done()
+			local := make([]FindMatch, 0, limit)
+			for _, file := range files {
+				if score, ok := fuzzyScore(file, query, caseSensitive); ok {
+					local = insertMatch(local, FindMatch{Path: file, Score: score}, limit)
+				}
+			}
+			mu.Lock()
+			matches = append(matches, local...)
+			mu.Unlock()
+		}(files[start:end])
+	}
+	wg.Wait()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return betterMatch(matches[i], matches[j])
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	for i := range matches {
+		file := matches[i].Path
+		_, positions, _ := fuzzyMatch(file, query, caseSensitive, true)
+		// Offsets are reported against the "/"-prefixed path
+		for j := range positions {
+			positions[j]++
+		}
+		matches[i].Path = "/" + file
+		matches[i].Name = path.Base(file)
+		matches[i].Positions = positions
+	}
+	if matches == nil {
+		matches = []FindMatch{}
+	}
+	return matches
+}
+
+// betterMatch orders by score, then shorter and lexically smaller paths.
+func betterMatch(a, b FindMatch) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if len(a.Path) != len(b.Path) {
+		return len(a.Path) < len(b.Path)
+	}
+	return a.Path < b.Path
+}
+
+// insertMatch adds m to the sorted best-first list, keeping at most limit.
+// Most candidates lose to the current worst and are rejected immediately.
+func insertMatch(matches []FindMatch, m FindMatch, limit int) []FindMatch {
+	if len(matches) == limit && !betterMatch(m, matches[len(matches)-1]) {
+		return matches
+	}
+	i := sort.Search(len(matches), func(i int) bool {
+		return betterMatch(m, matches[i])
+	})
+	if len(matches) < limit {
+		matches = append(matches, FindMatch{})
+	}
+	copy(matches[i+1:], matches[i:])
+	matches[i] = m
+	return matches
+}
+
+// Fuzzy scoring weights, modelled on fzf
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+	bonusBoundary     = 8
+	bonusSeparator    = 10
+	bonusCamel        = 7
+	bonusConsecutive  = 4
+	bonusFirstChar    = 2
+	bonusFileName     = 24
+)
+
+func fuzzyScore(name, query string, caseSensitive bool) (int, bool) {
+	score, _, ok := fuzzyMatch(name, query, caseSensitive, false)
+	return score, ok
+}
+
+// fuzzyMatch scores name against query. It first looks for the match inside
+// the file name, falling back to the whole path, and tightens the match by
+// scanning backwards from its end as fzf's v1 algorithm does. Positions are
+// only collected when asked for.
+func fuzzyMatch(name, query string, caseSensitive, withPositions bool) (int, []int, bool) {
+	start := strings.LastIndex(name, "/") + 1
+	if score, positions, ok := fuzzyMatchFrom(name, start, query, caseSensitive, withPositions); ok {
+		return score + bonusFileName, positions, true
+	}
+	if start == 0 {
+		return 0, nil, false
+	}
+	return fuzzyMatchFrom(name, 0, query, caseSensitive, withPositions)
+}
+
+func fuzzyMatchFrom(name string, from int, query string, caseSensitive, withPositions bool) (int, []int, bool) {
+	// Forward pass: find the end of the first in-order match
+	qi := 0
+	end := -1
+	for i := from; i < len(name); i++ {
+		if foldByte(name[i], caseSensitive) == foldByte(query[qi], caseSensitive) {
+			qi++
+			if qi == len(query) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	// Backward pass: find the latest start that still matches
+	qi = len(query) - 1
+	begin := end
+	for i := end; i >= from; i-- {
+		if foldByte(name[i], caseSensitive) == foldByte(query[qi], caseSensitive) {
+			qi--
+			if qi < 0 {
+				begin = i
+				break
+			}
+		}
+	}
+
+	// Score the match greedily from the tightened start
+	var positions []int
+	if withPositions {
+		positions = make([]int, 0, len(query))
+	}
+	score := 0
+	qi = 0
+	consecutive := 0
+	gap := false
+	for i := begin; i <= end && qi < len(query); i++ {
+		if foldByte(name[i], caseSensitive) != foldByte(query[qi], caseSensitive) {
+			if gap {
+				score += scoreGapExtension
+			} else {
+				score += scoreGapStart
+				gap = true
+			}
+			consecutive = 0
+			continue
+		}
+
+		bonus := boundaryBonus(name, i)
+		if qi == 0 {
+			bonus *= bonusFirstChar
+		}
+		if consecutive > 0 && bonus < bonusConsecutive {
+			bonus = bonusConsecutive
+		}
+		score += scoreMatch + bonus
+		if withPositions {
+			positions = append(positions, i)
+		}
+		consecutive++
+		gap = false
+		qi++
+	}
+	return score, positions, qi == len(query)
+}
+
+// boundaryBonus rewards matches at the start of a word.
+func boundaryBonus(name string, i int) int {
+	if i == 0 {
+		return bonusSeparator
+	}
+	prev, c := name[i-1], name[i]
+	switch {
+	case prev == '/':
+		return bonusSeparator
+	case prev == '_' || prev == '-' || prev == '.' || prev == ' ':
+		return bonusBoundary
+	case prev >= 'a' && prev <= 'z' && c >= 'A' && c <= 'Z':
+		return bonusCamel
+	case !isAlnum(prev) && isAlnum(c):
+		return bonusBoundary
+	}
+	return 0
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c)
+}
+
+func foldByte(c byte, caseSensitive bool) byte {
+	if !caseSensitive && c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,446 @@
+package vfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// ReplaceMatch is one replacement proposed by a preview. IDs are derived
+// from the file, offset and matched text, so the same content always yields
+// the same IDs.
+type ReplaceMatch struct {
+	ID          string `json:"id"`
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
+	EndColumn   int    `json:"endColumn"`
+	Text        string `json:"text"`
+	Replacement string `json:"replacement"`
+}
+
+// ReplaceFilePreview holds the proposed replacements for one file and the
+// unified diff that applying all of them would produce.
+type ReplaceFilePreview struct {
+	Path     string         `json:"path"`
+	Hash     string         `json:"hash"`               // Of the file's bytes, before decoding
+	Encoding string         `json:"encoding,omitempty"` // Set when the file isn't UTF-8
+	Diff     string         `json:"diff"`
+	Matches  []ReplaceMatch `json:"matches"`
+}
+
+// ReplacePreview is returned by a dry-run replacement.
+type ReplacePreview struct {
+	Files    []ReplaceFilePreview `json:"files"`
+	Matches  int                  `json:"matches"`
+	LimitHit bool                 `json:"limitHit"`
+}
+
+// errReplaceAllLimit rejects applying every match of a preview that hit
+// the match limit, since some of those matches were never shown
+var errReplaceAllLimit = errors.New(`the preview hit the match limit; pass the match IDs to apply instead of "all"`)
+
+// textEdit replaces content[start:end].
+type textEdit struct {
+	id          string
+	start, end  int
+	replacement string
+}
+
+// PreviewReplace computes the replacements ReplaceWorkspace would make,
+// without writing anything.
+func PreviewReplace(rootPath string, options SearchOptions) (ReplacePreview, error) {
+	preview := ReplacePreview{Files: []ReplaceFilePreview{}}
+	matcher, err := buildSearchRegexp(options)
+	if err != nil {
+		return preview, err
+	}
+	if err := validateSearchOptions(options); err != nil {
+		return preview, err
+	}
+	backend, err := Open(rootPath)
+	if err != nil {
+		return preview, err
+	}
+
+	err = walkReplaceFiles(backend, options, func(name string, text *textFile, info fs.FileInfo) error {
+		content := text.text
+		edits := replaceEdits(name, content, matcher, options)
+		if len(edits) == 0 {
+			return nil
+		}
+		remaining := options.maxResults() - preview.Matches
+		if remaining <= 0 {
+			preview.LimitHit = true
+			return fs.SkipAll
+		}
+		if len(edits) > remaining {
+			edits = edits[:remaining]
+			preview.LimitHit = true
+		}
+
+		file := ReplaceFilePreview{
+			Path:     "/" + name,
+			Hash:     ContentHash(text.raw),
+			Encoding: text.encoding,
+			Diff:     unifiedDiff(name, content, edits),
+			Matches:  make([]ReplaceMatch, 0, len(edits)),
+		}
+		for _, edit := range edits {
+			line, column := lineColumn(content, edit.start)
+			_, endColumn := lineColumn(content, edit.end)
+			file.Matches = append(file.Matches, ReplaceMatch{
+				ID:          edit.id,
+				Line:        line,
+				Column:      column,
+				EndColumn:   endColumn,
+				Text:        string(content[edit.start:edit.end]),
+				Replacement: edit.replacement,
+			})
+		}
+		preview.Files = append(preview.Files, file)
+		preview.Matches += len(edits)
+		if preview.LimitHit {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return preview, err
+}
+
+// ReplaceWorkspace replaces search matches in text files below rootPath.
+// When options carries the content hashes from a preview only those files
+// are touched, only the listed match IDs are applied, and files whose
+// content changed since the preview are reported as conflicts.
+func ReplaceWorkspace(rootPath string, options SearchOptions) (ReplaceResult, error) {
+	var result ReplaceResult
+	matcher, err := buildSearchRegexp(options)
+	if err != nil {
+		return result, err
+	}
+	if err := validateSearchOptions(options); err != nil {
+		return result, err
+	}
+
+	backend, err := Open(rootPath)
+	if err != nil {
+		return result, err
+	}
+
+	if options.Hashes != nil {
+		return applyReplace(rootPath, backend, matcher, options)
+	}
+
+	var changed []ChangesetFile
+	err = walkReplaceFiles(backend, options, func(name string, text *textFile, info fs.FileInfo) error {
+		edits := replaceEdits(name, text.text, matcher, options)
+		if len(edits) == 0 {
+			return nil
+		}
+		replaced, err := text.encode(applyEdits(text.text, edits))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := backend.WriteFile(name, replaced, info.Mode()); err != nil {
+			return err
+		}
+		changed = append(changed, newChangesetFile(name, text.raw, replaced, len(edits)))
+
+		result.Files++
+		result.Replacements += len(edits)
+		result.Paths = append(result.Paths, "/"+name)
+		return nil
+	})
+
+	result.Changeset = recordChangeset(rootPath, options, changed)
+	return result, err
+}
+
+// applyReplace applies a reviewed subset of a preview: the selected match
+// IDs, or every match in the hashed files when All is set.
+func applyReplace(rootPath string, backend Backend, matcher *regexp.Regexp, options SearchOptions) (result ReplaceResult, err error) {
+	// Record whatever was written, even if a later file fails
+	var changed []ChangesetFile
+	defer func() { result.Changeset = recordChangeset(rootPath, options, changed) }()
+
+	// A preview cut off at the limit didn't show every match in its files
+	if options.All {
+		preview, err := PreviewReplace(rootPath, options)
+		if err != nil {
+			return result, err
+		}
+		if preview.LimitHit {
+			return result, errReplaceAllLimit
+		}
+	}
+
+	selected := make(map[string]bool, len(options.Matches))
+	for _, id := range options.Matches {
+		selected[id] = true
+	}
+
+	paths := make([]string, 0, len(options.Hashes))
+	for p := range options.Hashes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		name, err := cleanAndValidatePath(p)
+		if err != nil {
+			return result, err
+		}
+		info, err := backend.Stat(name)
+		if err != nil {
+			result.Conflicts = append(result.Conflicts, "/"+name)
+			continue
+		}
+		raw, err := backend.ReadFile(name)
+		if err != nil || ContentHash(raw) != options.Hashes[p] {
+			result.Conflicts = append(result.Conflicts, "/"+name)
+			continue
+		}
+		text, ok := decodeTextFile(raw, options.Encoding)
+		if !ok {
+			result.Skipped = append(result.Skipped, "/"+name)
+			continue
+		}
+
+		edits := replaceEdits(name, text.text, matcher, options)
+		if !options.All {
+			kept := edits[:0]
+			for _, edit := range edits {
+				if selected[edit.id] {
+					kept = append(kept, edit)
+				}
+			}
+			edits = kept
+		}
+		if len(edits) == 0 {
+			continue
+		}
+
+		replaced, err := text.encode(applyEdits(text.text, edits))
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := backend.WriteFile(name, replaced, info.Mode()); err != nil {
+			return result, err
+		}
+		changed = append(changed, newChangesetFile(name, raw, replaced, len(edits)))
+		result.Files++
+		result.Replacements += len(edits)
+		result.Paths = append(result.Paths, "/"+name)
+	}
+	return result, nil
+}
+
+// walkReplaceFiles calls fn with the decoded content of every text file the
+// options select.
+func walkReplaceFiles(backend Backend, options SearchOptions, fn func(name string, text *textFile, info fs.FileInfo) error) error {
+	ignore := newIgnorer(backend, nil)
+	return walkSearchTree(backend, options, func(name string, entry fs.DirEntry) error {
+		if ignore.Ignored(name, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		if !matchesSearchFilters(name, options) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.Size() > options.maxFileSize() {
+			return nil
+		}
+
+		content, err := backend.ReadFile(name)
+		if err != nil {
+			return nil
+		}
+		text, ok := decodeTextFile(content, options.Encoding)
+		if !ok {
+			return nil
+		}
+		return fn(name, text, info)
+	})
+}
+
+// replaceEdits finds every match in content together with its replacement.
+func replaceEdits(name string, content []byte, matcher *regexp.Regexp, options SearchOptions) []textEdit {
+	var edits []textEdit
+	for _, loc := range matcher.FindAllSubmatchIndex(content, -1) {
+		replacement := options.Replace
+		if options.UseRegex {
+			replacement = expandReplacement(matcher, options.Replace, content, loc)
+		}
+		if options.PreserveCase {
+			replacement = preserveCase(string(content[loc[0]:loc[1]]), replacement)
+		}
+		edits = append(edits, textEdit{
+			id:          matchID(name, loc[0], content[loc[0]:loc[1]]),
+			start:       loc[0],
+			end:         loc[1],
+			replacement: replacement,
+		})
+	}
+	return edits
+}
+
+func matchID(name string, offset int, text []byte) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%s", name, offset, text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ContentHash identifies file content in replace previews, changesets and
+// change notifications.
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// applyEdits returns content with the sorted, non-overlapping edits applied.
+func applyEdits(content []byte, edits []textEdit) []byte {
+	var out []byte
+	last := 0
+	for _, edit := range edits {
+		out = append(out, content[last:edit.start]...)
+		out = append(out, edit.replacement...)
+		last = edit.end
+	}
+	return append(out, content[last:]...)
+}
+
+// lineColumn returns the 1-based line and rune column of a byte offset.
+func lineColumn(content []byte, offset int) (int, int) {
+	line := 1 + strings.Count(string(content[:offset]), "\n")
+	lineStart := strings.LastIndexByte(string(content[:offset]), '\n') + 1
+	return line, utf8.RuneCount(content[lineStart:offset]) + 1
+}
+
+// lineChange replaces old lines [first, last] with new lines.
+type lineChange struct {
+	first, last int
+	lines       []string
+}
+
+// unifiedDiff renders the effect of edits on content as a unified diff.
+// Edits touching the same lines are grouped into one change, and changes
+// closer than twice the context share a hunk.
+func unifiedDiff(name string, content []byte, edits []textEdit) string {
+	oldLines := strings.SplitAfter(string(content), "\n")
+	if len(oldLines) > 1 && oldLines[len(oldLines)-1] == "" {
+		oldLines = oldLines[:len(oldLines)-1]
+	}
+	lineStarts := make([]int, len(oldLines)+1)
+	for i, line := range oldLines {
+		lineStarts[i+1] = lineStarts[i] + len(line)
+	}
+	lineOf := func(offset int) int {
+		return max(sort.Search(len(oldLines), func(i int) bool { return lineStarts[i+1] > offset }), 0)
+	}
+
+	// Group edits by the lines they touch
+	var changes []lineChange
+	var group []textEdit
+	first, last := -1, -1
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+		start := lineStarts[first]
+		end := lineStarts[min(last+1, len(oldLines))]
+		shifted := make([]textEdit, len(group))
+		for i, edit := range group {
+			shifted[i] = textEdit{start: edit.start - start, end: edit.end - start, replacement: edit.replacement}
+		}
+		newText := string(applyEdits(content[start:end], shifted))
+		newLines := strings.SplitAfter(newText, "\n")
+		if newLines[len(newLines)-1] == "" {
+			newLines = newLines[:len(newLines)-1]
+		}
+		changes = append(changes, lineChange{first: first, last: last, lines: newLines})
+		group = nil
+	}
+	for _, edit := range edits {
+		editFirst := min(lineOf(edit.start), len(oldLines)-1)
+		editLast := editFirst
+		if edit.end > edit.start {
+			editLast = lineOf(edit.end - 1)
+		}
+		if len(group) > 0 && editFirst > last {
+			flush()
+		}
+		if len(group) == 0 {
+			first, last = editFirst, editLast
+		}
+		group = append(group, edit)
+		last = max(last, editLast)
+	}
+	flush()
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- a/%s\n+++ b/%s\n", name, name)
+	var body strings.Builder
+	writeLine := func(prefix, line string) {
+		body.WriteString(prefix)
+		body.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			body.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+
+	delta := 0
+	for i := 0; i < len(changes); {
+		// Extend the hunk while the next change is within reach
+		j := i
+		for j+1 < len(changes) && changes[j+1].first-changes[j].last <= 2*diffContext+1 {
+			j++
+		}
+		start := max(changes[i].first-diffContext, 0)
+		end := min(changes[j].last+diffContext, len(oldLines)-1)
+
+		body.Reset()
+		oldCount, newCount := 0, 0
+		line := start
+		for k := i; k <= j; k++ {
+			for ; line < changes[k].first; line++ {
+				writeLine(" ", oldLines[line])
+				oldCount++
+				newCount++
+			}
+			for ; line <= changes[k].last; line++ {
+				writeLine("-", oldLines[line])
+				oldCount++
+			}
+			for _, newLine := range changes[k].lines {
+				writeLine("+", newLine)
+				newCount++
+			}
+		}
+		for ; line <= end; line++ {
+			writeLine(" ", oldLines[line])
+			oldCount++
+			newCount++
+		}
+
+		fmt.Fprintf(&diff, "@@ -%d,%d +%d,%d @@\n", start+1, oldCount, start+1+delta, newCount)
+		diff.WriteString(body.String())
+		delta += newCount - oldCount
+		i = j + 1
+	}
+	return diff.String()
+}
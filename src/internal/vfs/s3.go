@@ -0,0 +1,294 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PageSize is the number of keys requested per ListObjectsV2 call.
+const s3PageSize = 1000
+
+// S3Backend serves a workspace from a bucket prefix on any S3-compatible
+// object store. Key prefixes ending in "/" are presented as folders.
+type S3Backend struct {
+	client *minio.Core
+	bucket string
+	prefix string
+}
+
+// NewS3Backend returns a backend for the objects below prefix in bucket.
+func NewS3Backend(client *minio.Core, bucket, prefix string) *S3Backend {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3Backend{client: client, bucket: bucket, prefix: prefix}
+}
+
+func (b *S3Backend) key(name string) string {
+	return b.prefix + strings.Trim(name, "/")
+}
+
+func (b *S3Backend) dirPrefix(name string) string {
+	if name = strings.Trim(name, "/"); name == "" || name == "." {
+		return b.prefix
+	}
+	return b.prefix + name + "/"
+}
+
+func (b *S3Backend) Stat(name string) (fs.FileInfo, error) {
+	if name == "" || name == "." {
+		return &entryInfo{name: path.Base(b.prefix), dir: true}, nil
+	}
+
+	object, err := b.client.StatObject(context.Background(), b.bucket, b.key(name), minio.StatObjectOptions{})
+	if err == nil {
+		return &entryInfo{name: path.Base(name), size: object.Size, modTime: object.LastModified}, nil
+	}
+	if !isS3NotFound(err) {
+		return nil, err
+	}
+
+	page, err := b.client.ListObjectsV2(b.bucket, b.dirPrefix(name), "", "", "/", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Contents) == 0 && len(page.CommonPrefixes) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &entryInfo{name: path.Base(name), dir: true}, nil
+}
+
+// ReadDir lists one level below name, paging through ListObjectsV2 with a
+// "/" delimiter so only that level is fetched.
+func (b *S3Backend) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := b.dirPrefix(name)
+	var entries []fs.DirEntry
+	exists := name == "" || name == "."
+
+	token := ""
+	for {
+		page, err := b.client.ListObjectsV2(b.bucket, prefix, "", token, "/", s3PageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, common := range page.CommonPrefixes {
+			exists = true
+			dirName := strings.TrimSuffix(strings.TrimPrefix(common.Prefix, prefix), "/")
+			if dirName != "" {
+				entries = append(entries, fs.FileInfoToDirEntry(&entryInfo{name: dirName, dir: true}))
+			}
+		}
+		for _, object := range page.Contents {
+			exists = true
+			fileName := strings.TrimPrefix(object.Key, prefix)
+			if fileName == "" {
+				// Folder marker object
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(&entryInfo{
+				name:    fileName,
+				size:    object.Size,
+				modTime: object.LastModified,
+			}))
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+
+	if !exists {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (b *S3Backend) ReadFile(name string) ([]byte, error) {
+	object, err := b.client.Client.GetObject(context.Background(), b.bucket, b.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3PathError("open", name, err)
+	}
+	defer object.Close()
+
+	content, err := io.ReadAll(object)
+	if err != nil {
+		return nil, s3PathError("read", name, err)
+	}
+	return content, nil
+}
+
+func (b *S3Backend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	_, err := b.client.Client.PutObject(context.Background(), b.bucket, b.key(name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	return err
+}
+
+// MkdirAll writes a zero-byte "name/" marker so empty folders survive. A
+// prefix that already holds objects is a folder already and is left alone.
+func (b *S3Backend) MkdirAll(name string, perm fs.FileMode) error {
+	if name = strings.Trim(name, "/"); name == "" || name == "." {
+		return nil
+	}
+	page, err := b.client.ListObjectsV2(b.bucket, b.dirPrefix(name), "", "", "", 1)
+	if err != nil {
+		return err
+	}
+	if len(page.Contents) > 0 {
+		return nil
+	}
+	_, err = b.client.Client.PutObject(context.Background(), b.bucket, b.dirPrefix(name), bytes.NewReader(nil), 0, minio.PutObjectOptions{})
+	return err
+}
+
+func (b *S3Backend) RemoveAll(name string) error {
+	keys, err := b.listKeys(b.dirPrefix(name))
+	if err != nil {
+		return err
+	}
+	if name != "" && name != "." {
+		keys = append(keys, b.key(name))
+	}
+
+	objects := make(chan minio.ObjectInfo, len(keys))
+	for _, key := range keys {
+		objects <- minio.ObjectInfo{Key: key}
+	}
+	close(objects)
+
+	for removeErr := range b.client.RemoveObjects(context.Background(), b.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if !isS3NotFound(removeErr.Err) {
+			return removeErr.Err
+		}
+	}
+	return nil
+}
+
+// Rename copies every object to its new key and then deletes the original,
+// since object stores have no native rename.
+func (b *S3Backend) Rename(oldName, newName string) error {
+	ctx := context.Background()
+	if _, err := b.client.StatObject(ctx, b.bucket, b.key(oldName), minio.StatObjectOptions{}); err == nil {
+		if err := b.copyObject(b.key(oldName), b.key(newName)); err != nil {
+			return err
+		}
+		return b.client.RemoveObject(ctx, b.bucket, b.key(oldName), minio.RemoveObjectOptions{})
+	} else if !isS3NotFound(err) {
+		return err
+	}
+
+	oldPrefix, newPrefix := b.dirPrefix(oldName), b.dirPrefix(newName)
+	keys, err := b.listKeys(oldPrefix)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	for _, key := range keys {
+		if err := b.copyObject(key, newPrefix+strings.TrimPrefix(key, oldPrefix)); err != nil {
+			return err
+		}
+	}
+	return b.RemoveAll(oldName)
+}
+
+func (b *S3Backend) copyObject(src, dst string) error {
+	_, err := b.client.Client.CopyObject(
+		context.Background(),
+		minio.CopyDestOptions{Bucket: b.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: b.bucket, Object: src},
+	)
+	return err
+}
+
+// listKeys returns every key below prefix, one page at a time.
+func (b *S3Backend) listKeys(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		page, err := b.client.ListObjectsV2(b.bucket, prefix, "", token, "", s3PageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func isS3NotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	resp := minio.ToErrorResponse(err)
+	return resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey"
+}
+
+func s3PathError(op, name string, err error) error {
+	if isS3NotFound(err) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// isS3Root reports whether root has the form s3://bucket[/prefix].
+func isS3Root(root string) bool {
+	return strings.HasPrefix(root, "s3://")
+}
+
+// newS3Backend connects to the endpoint in NANO_IDE_S3_ENDPOINT (AWS when
+// unset) using NANO_IDE_S3_ACCESS_KEY/NANO_IDE_S3_SECRET_KEY, falling back
+// to the usual AWS and MinIO credential sources.
+func newS3Backend(root string) (*S3Backend, error) {
+	u, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := os.Getenv("NANO_IDE_S3_ENDPOINT")
+	secure := true
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	} else if e, err := url.Parse(endpoint); err == nil && e.Host != "" {
+		endpoint = e.Host
+		secure = e.Scheme != "http"
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	if accessKey := os.Getenv("NANO_IDE_S3_ACCESS_KEY"); accessKey != "" {
+		creds = credentials.NewStaticV4(accessKey, os.Getenv("NANO_IDE_S3_SECRET_KEY"), "")
+	}
+
+	client, err := minio.NewCore(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: os.Getenv("NANO_IDE_S3_REGION"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewS3Backend(client, u.Host, u.Path), nil
+}
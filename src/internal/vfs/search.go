@@ -0,0 +1,467 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// searchChunkSize is the read size for local files; binary files are
+	// detected from the first chunk without reading the rest
+	searchChunkSize = 64 * 1024
+
+	// searchQueueSize bounds the files waiting for a matcher worker
+	searchQueueSize = 256
+
+	// remoteSearchWorkers is the worker count for SFTP and S3 workspaces,
+	// where workers mostly wait on the network
+	remoteSearchWorkers = 8
+)
+
+var (
+	errBinaryFile   = errors.New("binary file")
+	errFileTooLarge = errors.New("file too large to search")
+)
+
+// searchJob is one file handed from the walker to the matcher workers. The
+// sequence number restores walk order when results are emitted.
+type searchJob struct {
+	seq     int
+	name    string
+	size    int64
+	archive bool
+}
+
+type searchOutput struct {
+	seq   int
+	files []SearchFileResult
+}
+
+// SearchWorkspace searches text files below rootPath.
+func SearchWorkspace(rootPath string, options SearchOptions) (SearchResult, error) {
+	var files []SearchFileResult
+	result, err := StreamSearch(context.Background(), rootPath, options, func(fileResult SearchFileResult) error {
+		files = append(files, fileResult)
+		return nil
+	})
+	result.Files = files
+	return result, err
+}
+
+// StreamSearch searches text files below rootPath, passing each file's
+// matches to emit as soon as the file is scanned. The returned result holds
+// the totals only. The walk stops early when ctx is cancelled or emit fails.
+//
+// A single walker feeds a bounded pool of matcher workers; results are
+// re-sequenced so files are always emitted in walk order.
+func StreamSearch(ctx context.Context, rootPath string, options SearchOptions, emit func(SearchFileResult) error) (SearchResult, error) {
+	result := SearchResult{}
+	matcher, err := buildSearchRegexp(options)
+	if err != nil {
+		return result, err
+	}
+
+	backend, err := Open(rootPath)
+	if err != nil {
+		return result, err
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := validateSearchOptions(options); err != nil {
+		return result, err
+	}
+
+	prefilter := searchPrefilter(options, matcher)
+	jobs := make(chan searchJob, searchQueueSize)
+	outputs := make(chan searchOutput, searchQueueSize)
+
+	// A ready trigram index narrows the files to scan. Archive contents,
+	// symlinks, files above the default size limit and non-UTF-8 text aren't
+	// indexed, so searches that want them always walk.
+	var candidates []indexCandidate
+	indexed := false
+	if !options.Archives && !options.FollowSymlinks && options.Encoding == "" && options.maxFileSize() <= maxSearchFileSize {
+		if idx := readySearchIndex(rootPath); idx != nil {
+			candidates, indexed = idx.candidates(matcher.String())
+		}
+	}
+
+	var walkErr error
+	go func() {
+		defer close(jobs)
+		if indexed {
+			walkErr = sendSearchCandidates(ctx, candidates, options, jobs)
+		} else {
+			walkErr = walkSearchFiles(ctx, backend, options, jobs)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < searchWorkers(backend); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf []byte
+			for job := range jobs {
+				out := searchOutput{seq: job.seq}
+				if job.archive {
+					out.files = searchArchive(backend, job.name, options, matcher, prefilter)
+				} else {
+					out.files, buf = searchFile(backend, job, options, matcher, prefilter, buf)
+				}
+				select {
+				case outputs <- out:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outputs)
+	}()
+
+	collector := &searchCollector{emit: emit, limit: options.maxResults(), perFile: options.MaxPerFile}
+	pending := make(map[int][]SearchFileResult)
+	next := 0
+	var emitErr error
+	for out := range outputs {
+		if emitErr != nil {
+			continue
+		}
+		pending[out.seq] = out.files
+		for emitErr == nil {
+			files, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			for _, fileResult := range files {
+				if emitErr = collector.add(fileResult); emitErr != nil {
+					cancel()
+					break
+				}
+			}
+		}
+	}
+
+	result.Matches = collector.matches
+	result.LimitHit = collector.limitHit
+	if err := parent.Err(); err != nil {
+		return result, err
+	}
+	if emitErr != nil {
+		if emitErr == fs.SkipAll {
+			return result, nil
+		}
+		return result, emitErr
+	}
+	return result, walkErr
+}
+
+// walkSearchFiles sends every searchable file below the root to jobs in
+// walk order.
+func walkSearchFiles(ctx context.Context, backend Backend, options SearchOptions, jobs chan<- searchJob) error {
+	ignore := newIgnorer(backend, nil)
+	seq := 0
+	return walkSearchTree(backend, options, func(name string, entry fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if ignore.Ignored(name, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		job := searchJob{name: name}
+		// Filters apply to the entries inside an archive, not the archive
+		if options.Archives && isArchiveName(name) {
+			job.archive = true
+		} else {
+			if !matchesSearchFilters(name, options) {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil || info.Size() > options.maxFileSize() {
+				return nil
+			}
+			job.size = info.Size()
+		}
+
+		job.seq = seq
+		seq++
+		select {
+		case jobs <- job:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// sendSearchCandidates sends the files picked by the search index to jobs.
+func sendSearchCandidates(ctx context.Context, candidates []indexCandidate, options SearchOptions, jobs chan<- searchJob) error {
+	seq := 0
+	for _, candidate := range candidates {
+		if !matchesSearchFilters(candidate.name, options) || candidate.size > options.maxFileSize() {
+			continue
+		}
+		select {
+		case jobs <- searchJob{seq: seq, name: candidate.name, size: candidate.size}:
+			seq++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// searchWorkers sizes the matcher pool: one worker per CPU for local disks,
+// more for remote backends whose reads are network bound.
+func searchWorkers(backend Backend) int {
+	if _, ok := backend.(*LocalBackend); ok {
+		return runtime.GOMAXPROCS(0)
+	}
+	return max(runtime.GOMAXPROCS(0), remoteSearchWorkers)
+}
+
+// searchFile scans one file, reusing buf for the read. It returns the
+// (possibly grown) buffer for the next file.
+func searchFile(backend Backend, job searchJob, options SearchOptions, matcher *regexp.Regexp, prefilter func([]byte) bool, buf []byte) ([]SearchFileResult, []byte) {
+	content, buf, err := readSearchFile(backend, job.name, job.size, options, buf)
+	if err != nil {
+		return nil, buf
+	}
+	fileResult, ok := searchText(job.name, content, options, matcher, prefilter)
+	if !ok {
+		return nil, buf
+	}
+	return []SearchFileResult{fileResult}, buf
+}
+
+// searchText decodes and scans one file's content. Without an encoding
+// option the prefilter runs before any decoding work.
+func searchText(name string, content []byte, options SearchOptions, matcher *regexp.Regexp, prefilter func([]byte) bool) (SearchFileResult, bool) {
+	if options.Encoding == "" && !prefilter(content) {
+		return SearchFileResult{}, false
+	}
+	file, ok := decodeTextFile(content, options.Encoding)
+	if !ok || (options.Encoding != "" && !prefilter(file.text)) {
+		return SearchFileResult{}, false
+	}
+	fileResult := searchFileContent(name, string(file.text), matcher, options)
+	fileResult.Encoding = file.encoding
+	return fileResult, len(fileResult.Matches) > 0
+}
+
+// readSearchFile reads a file for searching. Local files are read in chunks
+// into a reused buffer, giving up on the first chunk that contains a NUL
+// byte unless the search decodes other encodings; other backends fall back
+// to ReadFile.
+func readSearchFile(backend Backend, name string, size int64, options SearchOptions, buf []byte) ([]byte, []byte, error) {
+	local, ok := backend.(*LocalBackend)
+	if !ok {
+		content, err := backend.ReadFile(name)
+		return content, buf, err
+	}
+
+	file, err := os.Open(local.Path(name))
+	if err != nil {
+		return nil, buf, err
+	}
+	defer file.Close()
+
+	// One extra byte notices files that grew since they were listed
+	if int64(cap(buf)) < size+1 {
+		buf = make([]byte, 0, size+1)
+	}
+	buf = buf[:0]
+	for {
+		if len(buf) == cap(buf) {
+			if int64(len(buf)) > options.maxFileSize() {
+				return nil, buf, errFileTooLarge
+			}
+			buf = append(buf, 0)[:len(buf)]
+		}
+		end := min(len(buf)+searchChunkSize, cap(buf))
+		n, err := file.Read(buf[len(buf):end])
+		chunk := buf[len(buf) : len(buf)+n]
+		buf = buf[:len(buf)+n]
+		if options.Encoding == "" && hasNulByte(chunk) {
+			return nil, buf, errBinaryFile
+		}
+		if err == io.EOF {
+			return buf, buf, nil
+		}
+		if err != nil {
+			return nil, buf, err
+		}
+	}
+}
+
+// searchPrefilter returns a cheap test that rejects files which cannot
+// match. Plain-text queries use bytes.Index style scanning instead of the
+// regexp engine; regex queries test the whole file once before the per-line
+// scan, with ^ and $ matching at line breaks as they do per line. Patterns
+// anchored to the start or end of the text skip that test.
+func searchPrefilter(options SearchOptions, matcher *regexp.Regexp) func([]byte) bool {
+	if !options.UseRegex && options.Query != "" {
+		needle := []byte(options.Query)
+		if options.CaseSensitive {
+			return func(content []byte) bool {
+				return bytes.Contains(content, needle)
+			}
+		}
+		if isASCII(options.Query) {
+			return func(content []byte) bool {
+				return indexFoldASCII(content, needle) >= 0
+			}
+		}
+	}
+	if options.Multiline {
+		return matcher.Match
+	}
+	parsed, err := syntax.Parse(`(?m)`+matcher.String(), syntax.Perl)
+	if err != nil || hasTextAnchor(parsed) {
+		return func([]byte) bool { return true }
+	}
+	lineMatcher, err := regexp.Compile(`(?m)` + matcher.String())
+	if err != nil {
+		return func([]byte) bool { return true }
+	}
+	return func(content []byte) bool {
+		// $ doesn't match before \r, which the per-line scan trims
+		return bytes.IndexByte(content, '\r') >= 0 || lineMatcher.Match(content)
+	}
+}
+
+// hasTextAnchor reports whether a pattern uses \A, \z or (?-m) ^ and $,
+// which match at each line's ends in the per-line scan but only at the
+// file's ends when the whole file is tested.
+func hasTextAnchor(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpBeginText || re.Op == syntax.OpEndText {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasTextAnchor(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexFoldASCII is bytes.Index ignoring ASCII case. It jumps between
+// candidate first bytes with bytes.IndexByte.
+func indexFoldASCII(s, needle []byte) int {
+	lower, upper := foldByte(needle[0], false), needle[0]
+	if lower >= 'a' && lower <= 'z' {
+		upper = lower - 'a' + 'A'
+	}
+
+	offset := 0
+	for offset+len(needle) <= len(s) {
+		rest := s[offset : len(s)-len(needle)+1]
+		i := bytes.IndexByte(rest, lower)
+		if upper != lower {
+			if j := bytes.IndexByte(rest, upper); j >= 0 && (i < 0 || j < i) {
+				i = j
+			}
+		}
+		if i < 0 {
+			return -1
+		}
+		if bytes.EqualFold(s[offset+i:offset+i+len(needle)], needle) {
+			return offset + i
+		}
+		offset += i + 1
+	}
+	return -1
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// searchCollector counts matches across files and hands them to emit.
+type searchCollector struct {
+	emit     func(SearchFileResult) error
+	limit    int
+	perFile  int
+	matches  int
+	limitHit bool
+}
+
+// add emits a file's matches, truncated to the overall limit, returning
+// fs.SkipAll once the limit is hit
+func (c *searchCollector) add(fileResult SearchFileResult) error {
+	if len(fileResult.Matches) == 0 {
+		return nil
+	}
+
+	if c.perFile > 0 && len(fileResult.Matches) > c.perFile {
+		fileResult.Matches = fileResult.Matches[:c.perFile]
+	}
+	remaining := c.limit - c.matches
+	if len(fileResult.Matches) > remaining {
+		fileResult.Matches = fileResult.Matches[:remaining]
+		c.limitHit = true
+	}
+	if len(fileResult.Matches) > 0 {
+		c.matches += len(fileResult.Matches)
+		if err := c.emit(fileResult); err != nil {
+			return err
+		}
+	}
+	if c.limitHit {
+		return fs.SkipAll
+	}
+	return nil
+}
+
+// searchArchive searches the text files inside an archive
+func searchArchive(backend Backend, name string, options SearchOptions, matcher *regexp.Regexp, prefilter func([]byte) bool) []SearchFileResult {
+	idx, err := openArchive(backend, name)
+	if err != nil {
+		return nil
+	}
+
+	var files []SearchFileResult
+	for _, inner := range idx.files() {
+		entryName := name + archiveSeparator + "/" + inner
+		if !matchesSearchFilters(entryName, options) || idx.entries[inner].info.size > options.maxFileSize() {
+			continue
+		}
+
+		content, err := idx.readFile(inner)
+		if err != nil {
+			continue
+		}
+		if fileResult, ok := searchText(entryName, content, options, matcher, prefilter); ok {
+			files = append(files, fileResult)
+		}
+	}
+	return files
+}
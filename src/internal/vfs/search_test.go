@@ -0,0 +1,139 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSearchTree creates dirs directories of files source files each below
+// a temp root. Every third file mentions the search term.
+func writeSearchTree(tb testing.TB, dirs, files int) string {
+	tb.Helper()
+	root := tb.TempDir()
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("pkg%03d", d))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			var content strings.Builder
+			for line := 0; line < 200; line++ {
+				fmt.Fprintf(&content, "func handler%d(w io.Writer) error { return nil }\n", line)
+			}
+			if f%3 == 0 {
+				content.WriteString("// TODO: needle goes here\n")
+			}
+			name := filepath.Join(dir, fmt.Sprintf("file%03d.go", f))
+			if err := os.WriteFile(name, []byte(content.String()), 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+func TestSearchWorkspaceWalkOrder(t *testing.T) {
+	root := writeSearchTree(t, 8, 30)
+
+	var want []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(content), "needle") {
+			rel, _ := filepath.Rel(root, path)
+			want = append(want, "/"+filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := SearchWorkspace(root, SearchOptions{Query: "needle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, file := range result.Files {
+		got = append(got, file.Path)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("files out of walk order:\ngot  %v\nwant %v", got, want)
+	}
+	if result.Matches != len(want) {
+		t.Fatalf("matches = %d, want %d", result.Matches, len(want))
+	}
+}
+
+func TestSearchWorkspaceTextAnchors(t *testing.T) {
+	root := t.TempDir()
+	content := "package main\n\nfunc main() {}\n"
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each line is matched on its own, so text anchors match at its ends
+	for _, query := range []string{`\Afunc`, `\{\}\z`, `(?-m)^func main`} {
+		result, err := SearchWorkspace(root, SearchOptions{Query: query, UseRegex: true, CaseSensitive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Matches != 1 {
+			t.Errorf("%s: matches = %d, want 1", query, result.Matches)
+		}
+	}
+}
+
+func TestStreamSearchCancel(t *testing.T) {
+	root := writeSearchTree(t, 20, 50)
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	emitted := 0
+	_, err := StreamSearch(ctx, root, SearchOptions{Query: "needle"}, func(SearchFileResult) error {
+		emitted++
+		if emitted == 1 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if total := 20 * 50 / 3; emitted >= total {
+		t.Fatalf("emitted %d of %d files after cancel", emitted, total)
+	}
+
+	// The walker and workers must all have returned
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running after cancel, had %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func BenchmarkSearchWorkspace(b *testing.B) {
+	root := writeSearchTree(b, 20, 50)
+	options := SearchOptions{Query: "needle"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := SearchWorkspace(root, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
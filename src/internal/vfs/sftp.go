@@ -0,0 +1,392 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultSFTPPoolSize bounds concurrent SFTP sessions per host when
+// NANO_IDE_SFTP_POOL is unset.
+const defaultSFTPPoolSize = 4
+
+// defaultSSHTimeout bounds connecting to an SSH server when
+// NANO_IDE_SSH_TIMEOUT is unset.
+const defaultSSHTimeout = 15 * time.Second
+
+// SFTPDialer opens a new SFTP session. Closing the returned io.Closer (which
+// may be nil) releases everything the session holds, such as the SSH
+// connection underneath it.
+type SFTPDialer func() (*sftp.Client, io.Closer, error)
+
+// SFTPBackend serves a workspace from a remote directory over SFTP.
+type SFTPBackend struct {
+	root string
+	pool *sftpPool
+}
+
+// NewSFTPBackend returns a backend rooted at dir on the server reached by
+// dial, keeping at most poolSize sessions open at once.
+func NewSFTPBackend(dial SFTPDialer, dir string, poolSize int) *SFTPBackend {
+	return &SFTPBackend{root: dir, pool: newSFTPPool(dial, poolSize)}
+}
+
+func (b *SFTPBackend) path(name string) string {
+	if b.root == "" {
+		return path.Join(".", name)
+	}
+	return path.Join(b.root, name)
+}
+
+func (b *SFTPBackend) Stat(name string) (fs.FileInfo, error) {
+	var info fs.FileInfo
+	err := b.pool.do(func(c *sftp.Client) error {
+		var err error
+		info, err = c.Stat(b.path(name))
+		return err
+	})
+	return info, err
+}
+
+func (b *SFTPBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	var infos []os.FileInfo
+	err := b.pool.do(func(c *sftp.Client) error {
+		var err error
+		infos, err = c.ReadDir(b.path(name))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (b *SFTPBackend) ReadFile(name string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := b.pool.do(func(c *sftp.Client) error {
+		buf.Reset()
+		f, err := c.Open(b.path(name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.WriteTo(&buf)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (b *SFTPBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return b.pool.do(func(c *sftp.Client) error {
+		f, err := c.OpenFile(b.path(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+func (b *SFTPBackend) MkdirAll(name string, perm fs.FileMode) error {
+	return b.pool.do(func(c *sftp.Client) error {
+		return c.MkdirAll(b.path(name))
+	})
+}
+
+func (b *SFTPBackend) RemoveAll(name string) error {
+	return b.pool.do(func(c *sftp.Client) error {
+		return c.RemoveAll(b.path(name))
+	})
+}
+
+func (b *SFTPBackend) Rename(oldName, newName string) error {
+	return b.pool.do(func(c *sftp.Client) error {
+		if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+			return c.PosixRename(b.path(oldName), b.path(newName))
+		}
+		return c.Rename(b.path(oldName), b.path(newName))
+	})
+}
+
+type sftpSession struct {
+	client *sftp.Client
+	closer io.Closer
+}
+
+func (s *sftpSession) close() {
+	s.client.Close()
+	if s.closer != nil {
+		s.closer.Close()
+	}
+}
+
+// sftpPool hands out SFTP sessions, reusing idle ones and bounding how many
+// are in use at the same time.
+type sftpPool struct {
+	dial  SFTPDialer
+	slots chan struct{}
+
+	mu   sync.Mutex
+	idle []*sftpSession
+}
+
+func newSFTPPool(dial SFTPDialer, size int) *sftpPool {
+	if size <= 0 {
+		size = defaultSFTPPoolSize
+	}
+	return &sftpPool{dial: dial, slots: make(chan struct{}, size)}
+}
+
+// do runs fn with a pooled session. A session whose connection dropped is
+// discarded and fn is retried once on a fresh one.
+func (p *sftpPool) do(fn func(*sftp.Client) error) error {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	for attempt := 0; ; attempt++ {
+		s, err := p.get()
+		if err != nil {
+			return err
+		}
+		err = fn(s.client)
+		if !isConnectionError(err) {
+			p.put(s)
+			return err
+		}
+		s.close()
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (p *sftpPool) get() (*sftpSession, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		s := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return s, nil
+	}
+	p.mu.Unlock()
+
+	client, closer, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	return &sftpSession{client: client, closer: closer}, nil
+}
+
+func (p *sftpPool) put(s *sftpSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, s)
+}
+
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, sftp.ErrSSHFxNoConnection) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// sftpTarget is a parsed remote workspace root.
+type sftpTarget struct {
+	User string
+	Host string
+	Port int
+	Path string
+}
+
+func (t sftpTarget) addr() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// parseSFTPRoot recognises sftp://[user@]host[:port]/path and the scp-like
+// user@host:/path form.
+func parseSFTPRoot(root string) (sftpTarget, bool) {
+	if strings.HasPrefix(root, "sftp://") {
+		u, err := url.Parse(root)
+		if err != nil || u.Hostname() == "" {
+			return sftpTarget{}, false
+		}
+		t := sftpTarget{User: u.User.Username(), Host: u.Hostname(), Port: 22, Path: u.Path}
+		if port := u.Port(); port != "" {
+			if p, err := strconv.Atoi(port); err == nil {
+				t.Port = p
+			}
+		}
+		return t, true
+	}
+
+	at := strings.Index(root, "@")
+	colon := strings.Index(root, ":")
+	if at <= 0 || colon < at+2 || strings.ContainsAny(root[:colon], `/\`) {
+		return sftpTarget{}, false
+	}
+	return sftpTarget{User: root[:at], Host: root[at+1 : colon], Port: 22, Path: root[colon+1:]}, true
+}
+
+var (
+	sftpPoolsMu sync.Mutex
+	sftpPools   = map[string]*sftpPool{}
+)
+
+// newSFTPBackend returns a backend for target, sharing one session pool
+// between all workspaces on the same user@host:port.
+func newSFTPBackend(target sftpTarget) (*SFTPBackend, error) {
+	if target.User == "" {
+		target.User = os.Getenv("USER")
+	}
+
+	key := target.User + "@" + target.addr()
+	sftpPoolsMu.Lock()
+	defer sftpPoolsMu.Unlock()
+
+	pool, ok := sftpPools[key]
+	if !ok {
+		config, err := sshClientConfig(target.User)
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(os.Getenv("NANO_IDE_SFTP_POOL"))
+		pool = newSFTPPool(sshDialer(target.addr(), config), size)
+		sftpPools[key] = pool
+	}
+	return &SFTPBackend{root: target.Path, pool: pool}, nil
+}
+
+func sshDialer(addr string, config *ssh.ClientConfig) SFTPDialer {
+	return func() (*sftp.Client, io.Closer, error) {
+		// The agent is dialed for each handshake, so an agent connection
+		// that dropped doesn't break every later reconnect
+		dialConfig := *config
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if agentConn, err := net.Dial("unix", sock); err == nil {
+				defer agentConn.Close()
+				agentAuth := ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers)
+				dialConfig.Auth = append([]ssh.AuthMethod{agentAuth}, config.Auth...)
+			}
+		}
+
+		netConn, err := net.DialTimeout("tcp", addr, dialConfig.Timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ssh %s: %w", addr, err)
+		}
+		// The timeout also bounds the SSH handshake and the SFTP init, so an
+		// unresponsive host can't hold a pool slot
+		netConn.SetDeadline(time.Now().Add(dialConfig.Timeout))
+		sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, &dialConfig)
+		if err != nil {
+			netConn.Close()
+			return nil, nil, fmt.Errorf("ssh %s: %w", addr, err)
+		}
+		conn := ssh.NewClient(sshConn, chans, reqs)
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("sftp %s: %w", addr, err)
+		}
+		netConn.SetDeadline(time.Time{})
+		return client, conn, nil
+	}
+}
+
+// sshTimeout bounds connecting to an SSH server: NANO_IDE_SSH_TIMEOUT, or
+// defaultSSHTimeout.
+func sshTimeout() time.Duration {
+	if timeout, err := time.ParseDuration(os.Getenv("NANO_IDE_SSH_TIMEOUT")); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultSSHTimeout
+}
+
+// sshClientConfig authenticates with the user's private keys, to which
+// sshDialer adds the SSH agent for each connection, checking host keys
+// against known_hosts unless NANO_IDE_SSH_INSECURE is set.
+func sshClientConfig(user string) (*ssh.ClientConfig, error) {
+	home, _ := os.UserHomeDir()
+
+	var auths []ssh.AuthMethod
+	keyFiles := []string{
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_ecdsa"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+	}
+	if key := os.Getenv("NANO_IDE_SSH_KEY"); key != "" {
+		keyFiles = []string{key}
+	}
+	var signers []ssh.Signer
+	for _, keyFile := range keyFiles {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			// Passphrase-protected keys are left to the agent
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+	if len(auths) == 0 && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, errors.New("no SSH agent or private key available")
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if os.Getenv("NANO_IDE_SSH_INSECURE") == "" {
+		knownHosts := os.Getenv("NANO_IDE_SSH_KNOWN_HOSTS")
+		if knownHosts == "" {
+			knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		callback, err := knownhosts.New(knownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("load known_hosts: %w", err)
+		}
+		hostKeyCallback = callback
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshTimeout(),
+	}, nil
+}
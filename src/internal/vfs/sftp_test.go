@@ -0,0 +1,111 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// pipeSFTPDialer connects each session to an in-process SFTP server over
+// net.Pipe.
+func pipeSFTPDialer() SFTPDialer {
+	return func() (*sftp.Client, io.Closer, error) {
+		clientConn, serverConn := net.Pipe()
+		server, err := sftp.NewServer(serverConn)
+		if err != nil {
+			clientConn.Close()
+			serverConn.Close()
+			return nil, nil, err
+		}
+		go server.Serve()
+
+		client, err := sftp.NewClientPipe(clientConn, clientConn)
+		if err != nil {
+			server.Close()
+			return nil, nil, err
+		}
+		return client, server, nil
+	}
+}
+
+func TestSFTPBackend(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# demo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewSFTPBackend(pipeSFTPDialer(), dir, 2)
+	defer func() {
+		for _, s := range backend.pool.idle {
+			s.close()
+		}
+	}()
+
+	// List
+	entries, err := backend.ReadDir("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 2 || names[0] != "README.md" || names[1] != "src" || !entries[1].IsDir() {
+		t.Fatalf("ReadDir = %v, want [README.md src/]", names)
+	}
+
+	// Read
+	content, err := backend.ReadFile("src/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "package main\n" {
+		t.Fatalf("ReadFile = %q", content)
+	}
+
+	// Write
+	if err := backend.MkdirAll("docs/guide", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.WriteFile("docs/guide/intro.md", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "docs", "guide", "intro.md")); err != nil || string(content) != "hello" {
+		t.Fatalf("written file = %q, %v", content, err)
+	}
+
+	// Rename
+	if err := backend.Rename("docs/guide/intro.md", "docs/start.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat("docs/guide/intro.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat of old name: err = %v, want fs.ErrNotExist", err)
+	}
+	info, err := backend.Stat("docs/start.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len("hello")) {
+		t.Fatalf("renamed file size = %d", info.Size())
+	}
+
+	// Delete
+	if err := backend.RemoveAll("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("docs still exists: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package vfs
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// caseTransform is a case change requested by an escape in a regex
+// replacement template.
+type caseTransform int
+
+const (
+	caseNone  caseTransform = iota
+	caseUpper               // \U: upper-case until \E
+	caseLower               // \L: lower-case until \E
+)
+
+// expandReplacement expands a regex replacement template for one match.
+// Besides the $1 / ${name} references handled by regexp.Expand, \U and \L
+// upper- or lower-case the rest of the template up to \E, and \u and \l
+// change the case of the next character only. \\ is a literal backslash.
+func expandReplacement(matcher *regexp.Regexp, template string, content []byte, loc []int) string {
+	if !strings.Contains(template, `\`) {
+		return string(matcher.Expand(nil, []byte(template), content, loc))
+	}
+
+	var out strings.Builder
+	mode := caseNone
+	var next caseTransform // pending \u (caseUpper) or \l (caseLower)
+	write := func(s string) {
+		switch mode {
+		case caseUpper:
+			s = strings.ToUpper(s)
+		case caseLower:
+			s = strings.ToLower(s)
+		}
+		if next != caseNone && s != "" {
+			r, size := utf8.DecodeRuneInString(s)
+			if next == caseUpper {
+				r = unicode.ToUpper(r)
+			} else {
+				r = unicode.ToLower(r)
+			}
+			out.WriteRune(r)
+			s = s[size:]
+			next = caseNone
+		}
+		out.WriteString(s)
+	}
+
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			write(string(matcher.Expand(nil, []byte(template[start:end]), content, loc)))
+		}
+	}
+	for i := 0; i+1 < len(template); i++ {
+		if template[i] != '\\' {
+			continue
+		}
+		switch template[i+1] {
+		case 'U', 'L', 'E', 'u', 'l', '\\':
+		default:
+			continue
+		}
+		flush(i)
+		switch template[i+1] {
+		case 'U':
+			mode = caseUpper
+		case 'L':
+			mode = caseLower
+		case 'E':
+			mode = caseNone
+		case 'u':
+			next = caseUpper
+		case 'l':
+			next = caseLower
+		case '\\':
+			write(`\`)
+		}
+		i++
+		start = i + 1
+	}
+	flush(len(template))
+	return out.String()
+}
+
+// preserveCase adapts replacement to the casing of the text it replaces.
+// All-caps text gets an all-caps replacement (USER -> ACCOUNT); otherwise
+// only the first letter follows the match (User -> Account,
+// user -> account).
+func preserveCase(matched, replacement string) string {
+	if replacement == "" {
+		return replacement
+	}
+	hasUpper, hasLower := false, false
+	for _, r := range matched {
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+	}
+	if hasUpper && !hasLower {
+		return strings.ToUpper(replacement)
+	}
+
+	first, _ := utf8.DecodeRuneInString(matched)
+	r, size := utf8.DecodeRuneInString(replacement)
+	if unicode.IsUpper(first) {
+		return string(unicode.ToUpper(r)) + replacement[size:]
+	}
+	if unicode.IsLower(first) {
+		return string(unicode.ToLower(r)) + replacement[size:]
+	}
+	return replacement
+}
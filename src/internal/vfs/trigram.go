@@ -0,0 +1,744 @@
+package vfs
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIndexUnsupported is returned when indexing a workspace that is not on
+// the local filesystem.
+var ErrIndexUnsupported = errors.New("search index requires a local workspace")
+
+const (
+	searchIndexVersion = 1
+
+	// searchIndexSaveDelay batches incremental updates before the index is
+	// written back to the cache directory
+	searchIndexSaveDelay = 30 * time.Second
+)
+
+// Search index states
+const (
+	IndexNone     = "none"
+	IndexBuilding = "building"
+	IndexReady    = "ready"
+	IndexFailed   = "failed"
+)
+
+// IndexStatus describes a workspace's trigram index.
+type IndexStatus struct {
+	Root      string    `json:"root"`
+	State     string    `json:"state"`
+	Path      string    `json:"path,omitempty"`
+	Files     int       `json:"files"`
+	Trigrams  int       `json:"trigrams"`
+	Bytes     int64     `json:"bytes"` // Size of the index file on disk
+	BuiltAt   time.Time `json:"builtAt,omitzero"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// indexedFile is one file in the index; files removed since the last
+// compaction keep their ID with an empty Name.
+type indexedFile struct {
+	Name    string
+	Size    int64
+	ModTime int64
+}
+
+// searchIndexFile is the on-disk form of a SearchIndex.
+type searchIndexFile struct {
+	Version  int
+	Root     string
+	BuiltAt  time.Time
+	Files    []indexedFile
+	Postings map[uint32][]uint32
+}
+
+// SearchIndex is a trigram index over a workspace's text files, in the
+// spirit of Google's codesearch: for every three-byte sequence it records
+// which files contain it. A query's required literals are split into
+// trigrams and the posting lists intersected to find the candidate files,
+// which are then verified with the real matcher.
+//
+// Trigrams are indexed with ASCII letters lower-cased so one index serves
+// both case-sensitive and case-insensitive searches.
+type SearchIndex struct {
+	root    string
+	backend Backend
+	ignore  *Ignorer
+	file    string
+
+	mu        sync.RWMutex
+	state     string
+	err       error
+	files     []indexedFile
+	ids       map[string]uint32
+	postings  map[uint32][]uint32
+	dead      int
+	builtAt   time.Time
+	updatedAt time.Time
+	saveTimer *time.Timer
+}
+
+var (
+	searchIndexesMu sync.Mutex
+	searchIndexes   = map[string]*SearchIndex{}
+)
+
+// searchIndexDir is where index files are kept: NANO_IDE_CACHE_DIR, or
+// nano-ide/index under the user cache directory.
+func searchIndexDir() (string, error) {
+	if dir := os.Getenv("NANO_IDE_CACHE_DIR"); dir != "" {
+		return filepath.Join(dir, "index"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "nano-ide", "index"), nil
+}
+
+func searchIndexPath(rootPath string) (string, error) {
+	dir, err := searchIndexDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(rootPath)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".idx"), nil
+}
+
+// autoIndex reports whether workspaces are indexed on first search without
+// an explicit rebuild.
+func autoIndex() bool {
+	v := os.Getenv("NANO_IDE_SEARCH_INDEX")
+	return v == "1" || v == "true"
+}
+
+// getSearchIndex returns the index for a workspace, loading it from the
+// cache directory if a saved index exists. With create set a missing index
+// is built in the background. It returns nil when there is no index.
+func getSearchIndex(rootPath string, create bool) (*SearchIndex, error) {
+	searchIndexesMu.Lock()
+	defer searchIndexesMu.Unlock()
+
+	if idx, ok := searchIndexes[rootPath]; ok {
+		return idx, nil
+	}
+	if !IsLocal(rootPath) {
+		return nil, ErrIndexUnsupported
+	}
+
+	file, err := searchIndexPath(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	_, statErr := os.Stat(file)
+	if statErr != nil && !create {
+		return nil, nil
+	}
+
+	backend, err := Open(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	idx := &SearchIndex{
+		root:    rootPath,
+		backend: backend,
+		ignore:  newIgnorer(backend, nil),
+		file:    file,
+		state:   IndexBuilding,
+	}
+	searchIndexes[rootPath] = idx
+
+	go func() {
+		if statErr == nil {
+			if err := idx.load(); err == nil {
+				idx.refresh()
+				return
+			}
+		}
+		idx.build()
+	}()
+	return idx, nil
+}
+
+// readySearchIndex returns the workspace's index if one is ready to answer
+// queries. Searches never wait for an index that is still building.
+func readySearchIndex(rootPath string) *SearchIndex {
+	idx, err := getSearchIndex(rootPath, autoIndex())
+	if err != nil || idx == nil {
+		return nil
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if idx.state != IndexReady {
+		return nil
+	}
+	return idx
+}
+
+// GetIndexStatus reports the state of a workspace's search index.
+func GetIndexStatus(rootPath string) (IndexStatus, error) {
+	idx, err := getSearchIndex(rootPath, false)
+	if err != nil {
+		return IndexStatus{}, err
+	}
+	if idx == nil {
+		return IndexStatus{Root: rootPath, State: IndexNone}, nil
+	}
+	return idx.Status(), nil
+}
+
+// RebuildSearchIndex starts a full rebuild of a workspace's search index,
+// creating it if needed. The rebuild runs in the background; poll
+// GetIndexStatus for progress.
+func RebuildSearchIndex(rootPath string) (IndexStatus, error) {
+	idx, err := getSearchIndex(rootPath, true)
+	if err != nil {
+		return IndexStatus{}, err
+	}
+
+	idx.mu.Lock()
+	building := idx.state == IndexBuilding
+	idx.state = IndexBuilding
+	idx.mu.Unlock()
+	if !building {
+		go idx.build()
+	}
+	return idx.Status(), nil
+}
+
+// UpdateSearchIndex applies a batch of changes reported by the file
+// watcher to the workspace's search index, if it has one.
+func UpdateSearchIndex(rootPath string, names []string) {
+	searchIndexesMu.Lock()
+	idx := searchIndexes[rootPath]
+	searchIndexesMu.Unlock()
+	if idx != nil {
+		idx.Update(names)
+	}
+}
+
+// Status returns the current index status.
+func (idx *SearchIndex) Status() IndexStatus {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	status := IndexStatus{
+		Root:      idx.root,
+		State:     idx.state,
+		Path:      idx.file,
+		Files:     len(idx.ids),
+		Trigrams:  len(idx.postings),
+		BuiltAt:   idx.builtAt,
+		UpdatedAt: idx.updatedAt,
+	}
+	if idx.err != nil {
+		status.Error = idx.err.Error()
+	}
+	if info, err := os.Stat(idx.file); err == nil {
+		status.Bytes = info.Size()
+	}
+	return status
+}
+
+// build indexes every file in the workspace from scratch.
+func (idx *SearchIndex) build() {
+	started := time.Now()
+	idx.ignore.Reset()
+	next := &SearchIndex{
+		ids:      make(map[string]uint32),
+		postings: make(map[uint32][]uint32),
+	}
+	err := walkBackend(idx.backend, "", func(name string, entry fs.DirEntry) error {
+		if idx.ignore.Ignored(name, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		next.addFile(idx.backend, name, info)
+		return nil
+	})
+
+	idx.mu.Lock()
+	if err != nil {
+		idx.state = IndexFailed
+		idx.err = err
+		idx.mu.Unlock()
+		log.Printf("Failed to build search index for %s: %v", idx.root, err)
+		return
+	}
+	idx.files = next.files
+	idx.ids = next.ids
+	idx.postings = next.postings
+	idx.dead = 0
+	idx.builtAt = time.Now()
+	idx.updatedAt = idx.builtAt
+	idx.state = IndexReady
+	idx.err = nil
+	idx.mu.Unlock()
+
+	log.Printf("Built search index for %s: %d files in %v", idx.root, len(next.ids), time.Since(started).Round(time.Millisecond))
+	if err := idx.save(); err != nil {
+		log.Printf("Failed to save search index for %s: %v", idx.root, err)
+	}
+}
+
+// addFile reads and indexes one file. The caller holds the write lock or
+// owns idx exclusively.
+func (idx *SearchIndex) addFile(backend Backend, name string, info fs.FileInfo) {
+	entry := indexedFile{Name: name, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	var trigrams []uint32
+	if info.Size() <= maxSearchFileSize {
+		if content, err := backend.ReadFile(name); err == nil && !hasNulByte(content) {
+			trigrams = contentTrigrams(content)
+		}
+	}
+
+	id := uint32(len(idx.files))
+	idx.files = append(idx.files, entry)
+	idx.ids[name] = id
+	for _, t := range trigrams {
+		idx.postings[t] = append(idx.postings[t], id)
+	}
+}
+
+// removeFile marks a file's ID dead; posting lists are cleaned up on the
+// next compaction. The caller holds the write lock.
+func (idx *SearchIndex) removeFile(name string) {
+	if id, ok := idx.ids[name]; ok {
+		idx.files[id].Name = ""
+		delete(idx.ids, name)
+		idx.dead++
+	}
+}
+
+// Update re-indexes workspace-relative paths after a batch of changes.
+// Removed paths drop the file or everything below the directory; a changed
+// ignore file triggers a full rebuild instead.
+func (idx *SearchIndex) Update(names []string) {
+	for _, name := range names {
+		base := path.Base(name)
+		if base == gitIgnoreFile || base == workspaceIgnoreFile {
+			RebuildSearchIndex(idx.root)
+			return
+		}
+	}
+
+	idx.mu.RLock()
+	ready := idx.state == IndexReady
+	idx.mu.RUnlock()
+	if !ready {
+		return
+	}
+
+	var removed []string
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		info, err := idx.backend.Stat(name)
+		if err != nil || idx.ignore.Ignored(name, info.IsDir()) || isSymlink(idx.backend, name) {
+			removed = append(removed, name)
+			continue
+		}
+		if !info.IsDir() {
+			idx.updateFile(name, info)
+			continue
+		}
+		walkBackend(idx.backend, name, func(child string, entry fs.DirEntry) error {
+			if idx.ignore.Ignored(child, entry.IsDir()) {
+				if entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !entry.IsDir() && entry.Type()&fs.ModeSymlink == 0 {
+				if info, err := entry.Info(); err == nil {
+					idx.updateFile(child, info)
+				}
+			}
+			return nil
+		})
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	// Files go directly; files below removed directories are found in one
+	// pass over the index for the whole batch
+	dirs := make(map[string]bool)
+	for _, name := range removed {
+		if _, ok := idx.ids[name]; ok {
+			idx.removeFile(name)
+		} else {
+			dirs[name] = true
+		}
+	}
+	if len(dirs) > 0 {
+		for file := range idx.ids {
+			for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+				if dirs[dir] {
+					idx.removeFile(file)
+					break
+				}
+			}
+		}
+	}
+	idx.changed()
+}
+
+// isSymlink reports whether name is a symlink on a local disk. Symlinks
+// aren't indexed, like searches don't follow them by default.
+func isSymlink(backend Backend, name string) bool {
+	local, ok := backend.(*LocalBackend)
+	if !ok {
+		return false
+	}
+	info, err := os.Lstat(local.Path(name))
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// updateFile re-indexes a file unless its size and modification time are
+// unchanged.
+func (idx *SearchIndex) updateFile(name string, info fs.FileInfo) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if id, ok := idx.ids[name]; ok {
+		old := idx.files[id]
+		if old.Size == info.Size() && old.ModTime == info.ModTime().UnixNano() {
+			return
+		}
+		idx.removeFile(name)
+	}
+	idx.addFile(idx.backend, name, info)
+	idx.changed()
+}
+
+// changed records an incremental update and schedules a save. The caller
+// holds the write lock.
+func (idx *SearchIndex) changed() {
+	idx.updatedAt = time.Now()
+	if idx.dead > len(idx.ids)/4 {
+		idx.compact()
+	}
+	if idx.saveTimer == nil {
+		idx.saveTimer = time.AfterFunc(searchIndexSaveDelay, func() {
+			idx.mu.Lock()
+			idx.saveTimer = nil
+			idx.mu.Unlock()
+			if err := idx.save(); err != nil {
+				log.Printf("Failed to save search index for %s: %v", idx.root, err)
+			}
+		})
+	}
+}
+
+// compact renumbers the live files and drops dead IDs from every posting
+// list. The caller holds the write lock.
+func (idx *SearchIndex) compact() {
+	remap := make([]uint32, len(idx.files))
+	files := make([]indexedFile, 0, len(idx.ids))
+	for id, file := range idx.files {
+		if file.Name == "" {
+			continue
+		}
+		remap[id] = uint32(len(files))
+		idx.ids[file.Name] = uint32(len(files))
+		files = append(files, file)
+	}
+	for t, list := range idx.postings {
+		live := list[:0]
+		for _, id := range list {
+			if idx.files[id].Name != "" {
+				live = append(live, remap[id])
+			}
+		}
+		if len(live) == 0 {
+			delete(idx.postings, t)
+		} else {
+			idx.postings[t] = live
+		}
+	}
+	idx.files = files
+	idx.dead = 0
+}
+
+// refresh brings a freshly loaded index up to date with changes made while
+// the IDE wasn't running.
+func (idx *SearchIndex) refresh() {
+	seen := make(map[string]bool)
+	walkBackend(idx.backend, "", func(name string, entry fs.DirEntry) error {
+		if idx.ignore.Ignored(name, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && entry.Type()&fs.ModeSymlink == 0 {
+			seen[name] = true
+			if info, err := entry.Info(); err == nil {
+				idx.updateFile(name, info)
+			}
+		}
+		return nil
+	})
+
+	idx.mu.Lock()
+	for name := range idx.ids {
+		if !seen[name] {
+			idx.removeFile(name)
+		}
+	}
+	if idx.dead > 0 {
+		idx.changed()
+	}
+	idx.mu.Unlock()
+}
+
+func (idx *SearchIndex) load() error {
+	f, err := os.Open(idx.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var data searchIndexFile
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&data); err != nil {
+		return err
+	}
+	if data.Version != searchIndexVersion || data.Root != filepath.Clean(idx.root) {
+		return fmt.Errorf("%s: stale search index", idx.file)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.files = data.Files
+	idx.postings = data.Postings
+	if idx.postings == nil {
+		idx.postings = make(map[uint32][]uint32)
+	}
+	idx.ids = make(map[string]uint32, len(data.Files))
+	for id, file := range data.Files {
+		idx.ids[file.Name] = uint32(id)
+	}
+	idx.builtAt = data.BuiltAt
+	idx.updatedAt = data.BuiltAt
+	idx.state = IndexReady
+	return nil
+}
+
+// save compacts the index and writes it to the cache directory.
+func (idx *SearchIndex) save() error {
+	if err := os.MkdirAll(filepath.Dir(idx.file), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(idx.file), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	idx.mu.Lock()
+	if idx.dead > 0 {
+		idx.compact()
+	}
+	idx.mu.Unlock()
+
+	idx.mu.RLock()
+	w := bufio.NewWriter(tmp)
+	err = gob.NewEncoder(w).Encode(searchIndexFile{
+		Version:  searchIndexVersion,
+		Root:     filepath.Clean(idx.root),
+		BuiltAt:  idx.builtAt,
+		Files:    idx.files,
+		Postings: idx.postings,
+	})
+	idx.mu.RUnlock()
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), idx.file)
+}
+
+// indexCandidate is a file that may match a query.
+type indexCandidate struct {
+	name string
+	size int64
+}
+
+// candidates returns the files that contain every trigram the query
+// requires, in walk order. ok is false when the query has no usable
+// trigrams and every file has to be scanned.
+func (idx *SearchIndex) candidates(pattern string) (files []indexCandidate, ok bool) {
+	trigrams := queryTrigrams(pattern)
+	if len(trigrams) == 0 {
+		return nil, false
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	lists := make([][]uint32, 0, len(trigrams))
+	for _, t := range trigrams {
+		list := idx.postings[t]
+		if len(list) == 0 {
+			return nil, true
+		}
+		lists = append(lists, list)
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	ids := lists[0]
+	for _, list := range lists[1:] {
+		ids = intersectPostings(ids, list)
+		if len(ids) == 0 {
+			return nil, true
+		}
+	}
+
+	for _, id := range ids {
+		if file := idx.files[id]; file.Name != "" {
+			files = append(files, indexCandidate{name: file.Name, size: file.Size})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return walkOrderLess(files[i].name, files[j].name)
+	})
+	return files, true
+}
+
+// walkOrderLess orders names the way a depth-first walk of sorted
+// directories visits them.
+func walkOrderLess(a, b string) bool {
+	return strings.ReplaceAll(a, "/", "\x00") < strings.ReplaceAll(b, "/", "\x00")
+}
+
+func intersectPostings(a, b []uint32) []uint32 {
+	out := make([]uint32, 0, min(len(a), len(b)))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+func trigramOf(a, b, c byte) uint32 {
+	return uint32(foldByte(a, false))<<16 | uint32(foldByte(b, false))<<8 | uint32(foldByte(c, false))
+}
+
+// contentTrigrams returns the distinct trigrams in content.
+func contentTrigrams(content []byte) []uint32 {
+	if len(content) < 3 {
+		return nil
+	}
+	seen := make(map[uint32]struct{}, min(len(content), 1<<16))
+	for i := 0; i+2 < len(content); i++ {
+		seen[trigramOf(content[i], content[i+1], content[i+2])] = struct{}{}
+	}
+	trigrams := make([]uint32, 0, len(seen))
+	for t := range seen {
+		trigrams = append(trigrams, t)
+	}
+	return trigrams
+}
+
+// queryTrigrams extracts the trigrams every match of a regexp must contain,
+// from the literal runs that are not optional or alternated.
+func queryTrigrams(pattern string) []uint32 {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[uint32]bool)
+	var trigrams []uint32
+	for _, literal := range requiredLiterals(re.Simplify()) {
+		for i := 0; i+2 < len(literal); i++ {
+			t := trigramOf(literal[i], literal[i+1], literal[i+2])
+			if !seen[t] {
+				seen[t] = true
+				trigrams = append(trigrams, t)
+			}
+		}
+	}
+	return trigrams
+}
+
+// requiredLiterals returns literal strings that must appear in any match.
+// Case-folded literals are only used when ASCII, since the index folds
+// ASCII letters only.
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		literal := string(re.Rune)
+		if re.Flags&syntax.FoldCase != 0 && !isASCII(literal) {
+			return nil
+		}
+		return []string{literal}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var literals []string
+		var run strings.Builder
+		flush := func() {
+			if run.Len() > 0 {
+				literals = append(literals, run.String())
+				run.Reset()
+			}
+		}
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				if subLiterals := requiredLiterals(sub); len(subLiterals) > 0 {
+					run.WriteString(subLiterals[0])
+					continue
+				}
+			}
+			flush()
+			literals = append(literals, requiredLiterals(sub)...)
+		}
+		flush()
+		return literals
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package vfs
+
+import (
+	"strings"
+	"time"
+)
+
+// FileVersion identifies the content of a file, so clients can tell whether
+// a buffer they hold is stale.
+type FileVersion struct {
+	Hash    string    `json:"hash"`
+	ModTime time.Time `json:"mtime"`
+	Size    int64     `json:"size"`
+}
+
+// ReadFileVersion reads a file together with its version.
+func ReadFileVersion(filePath, rootPath string) ([]byte, FileVersion, error) {
+	backend, p, err := resolve(filePath, rootPath)
+	if err != nil {
+		return nil, FileVersion{}, err
+	}
+	info, err := backend.Stat(p)
+	if err != nil {
+		return nil, FileVersion{}, err
+	}
+	content, err := backend.ReadFile(p)
+	if err != nil {
+		return nil, FileVersion{}, err
+	}
+	return content, FileVersion{Hash: ContentHash(content), ModTime: info.ModTime(), Size: int64(len(content))}, nil
+}
+
+// DiffContent renders the change from old to new as a unified diff. Lines
+// the two have in common at the start and end are left out, so the change
+// is shown as one hunk from the first to the last differing line.
+func DiffContent(name string, old, new []byte) string {
+	if string(old) == string(new) {
+		return ""
+	}
+	oldLines := strings.SplitAfter(string(old), "\n")
+	newLines := strings.SplitAfter(string(new), "\n")
+
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	start := len(strings.Join(oldLines[:prefix], ""))
+	end := len(old) - len(strings.Join(oldLines[len(oldLines)-suffix:], ""))
+	newEnd := len(new) - len(strings.Join(newLines[len(newLines)-suffix:], ""))
+	edit := textEdit{start: start, end: end, replacement: string(new[start:newEnd])}
+	return unifiedDiff(strings.TrimPrefix(name, "/"), old, []textEdit{edit})
+}
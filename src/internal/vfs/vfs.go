@@ -2,11 +2,15 @@ package vfs
 
 import (
 	"bytes"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -18,14 +22,23 @@ type FileNode struct {
 	Children []*FileNode `json:"children,omitempty"`
 	HasMore  bool        `json:"hasMore,omitempty"` // Indicates if there are more children not loaded
 	Loaded   bool        `json:"loaded,omitempty"`  // Indicates if children have been loaded
+	Archive  bool        `json:"archive,omitempty"` // File can be browsed as a read-only folder via "<path>!/"
+	Ignored  bool        `json:"ignored,omitempty"` // Matched by an ignore rule (only returned with ShowIgnored)
+
+	// NextCursor continues a truncated Children list via TreeOptions.Cursor
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 // TreeOptions configures how the tree is built
 type TreeOptions struct {
 	MaxDepth     int      `json:"maxDepth"`     // Maximum depth to traverse
-	MaxFiles     int      `json:"maxFiles"`     // Maximum files per directory
-	SkipPatterns []string `json:"skipPatterns"` // Patterns to skip
+	MaxFiles     int      `json:"maxFiles"`     // Maximum entries per page of a directory
+	SkipPatterns []string `json:"skipPatterns"` // Extra gitignore-style patterns to skip
 	RootPath     string   `json:"rootPath"`     // Root path for the tree
+	ShowIgnored  bool     `json:"showIgnored"`  // Return ignored entries marked as such instead of hiding them
+	Cursor       string   `json:"cursor"`       // Continue a listing where a previous page stopped
+	Sort         string   `json:"sort"`         // "name" (default), "natural", "mtime" or "size"
+	MixFolders   bool     `json:"mixFolders"`   // Sort folders among files instead of first
 }
 
 // SearchOptions configures workspace text search.
@@ -37,22 +50,51 @@ type SearchOptions struct {
 	CaseSensitive bool   `json:"caseSensitive"`
 	WholeWord     bool   `json:"wholeWord"`
 	UseRegex      bool   `json:"useRegex"`
+	Archives      bool   `json:"archives,omitempty"`     // Also search inside zip/tar archives
+	PreserveCase  bool   `json:"preserveCase,omitempty"` // Match each replacement's case to the text it replaces
+
+	// Multiline matches the whole file instead of each line, so patterns may
+	// span line breaks; ^ and $ still match at line boundaries
+	Multiline bool `json:"multiline,omitempty"`
+	Before    int  `json:"before,omitempty"` // Context lines returned before each match
+	After     int  `json:"after,omitempty"`  // Context lines returned after each match
+
+	// Limits; zero means the default
+	MaxFileSize    int64 `json:"maxFileSize,omitempty"`    // Skip larger files (bytes)
+	MaxResults     int   `json:"maxResults,omitempty"`     // Stop after this many matches
+	MaxPerFile     int   `json:"maxPerFile,omitempty"`     // Keep at most this many matches per file; unlimited when zero
+	FollowSymlinks bool  `json:"followSymlinks,omitempty"` // Search through symlinked files and directories
+
+	// Encoding is "auto" to detect the encoding of files that aren't valid
+	// UTF-8, or the encoding to assume for them (e.g. "windows-1252")
+	Encoding string `json:"encoding,omitempty"`
+
+	// Replace previews and selective apply
+	DryRun  bool              `json:"dryRun,omitempty"`  // Return diffs and match IDs without writing
+	Matches []string          `json:"matches,omitempty"` // Match IDs from a preview to apply
+	All     bool              `json:"all,omitempty"`     // Apply every match in the hashed files instead; refused when the preview hit the limit
+	Hashes  map[string]string `json:"hashes,omitempty"`  // Content hash per path from the preview
 }
 
 // SearchMatch is a single text match inside a file.
 type SearchMatch struct {
 	Line          int    `json:"line"`
 	Column        int    `json:"column"`
+	EndLine       int    `json:"endLine"`
 	EndColumn     int    `json:"endColumn"`
-	LineText      string `json:"lineText"`
+	LineText      string `json:"lineText"` // All lines the match spans, without the final line break
 	PreviewStart  int    `json:"previewStart"`
 	PreviewLength int    `json:"previewLength"`
+
+	Before []string `json:"before,omitempty"` // Context lines preceding Line
+	After  []string `json:"after,omitempty"`  // Context lines following EndLine
 }
 
 // SearchFileResult contains all matches for one file.
 type SearchFileResult struct {
-	Path    string        `json:"path"`
-	Matches []SearchMatch `json:"matches"`
+	Path     string        `json:"path"`
+	Encoding string        `json:"encoding,omitempty"` // Set when the file isn't UTF-8
+	Matches  []SearchMatch `json:"matches"`
 }
 
 // SearchResult is returned by workspace text search.
@@ -67,44 +109,44 @@ type ReplaceResult struct {
 	Files        int      `json:"files"`
 	Replacements int      `json:"replacements"`
 	Paths        []string `json:"paths"`
+	Conflicts    []string `json:"conflicts,omitempty"` // Files changed since the preview, left untouched
+	Skipped      []string `json:"skipped,omitempty"`   // Files that can't be decoded as text, left untouched
+	Changeset    string   `json:"changeset,omitempty"` // ID for reverting this replace
 }
 
 const (
 	maxSearchFileSize = 2 * 1024 * 1024
 	maxSearchMatches  = 10000
+
+	// maxSearchContext caps the before/after context lines per match
+	maxSearchContext = 20
 )
 
-var (
-	// Default skip directories
-	skipDirs = map[string]bool{
-		"node_modules":  true,
-		".git":          true,
-		".next":         true,
-		".pnpm":         true,
-		".vscode":       true,
-		".idea":         true,
-		"__pycache__":   true,
-		".nuxt":         true,
-		".venv":         true,
-		"venv":          true,
-		".pipenv":       true,
-		".pytest_cache": true,
-		".ruff_cache":   true,
-		".mypy_cache":   true,
-		"target":        true,
-		"build":         true,
-		"dist":          true,
+// maxFileSize returns the size limit for searched files.
+func (o SearchOptions) maxFileSize() int64 {
+	if o.MaxFileSize > 0 {
+		return o.MaxFileSize
 	}
-)
+	return maxSearchFileSize
+}
+
+// maxResults returns the total match limit.
+func (o SearchOptions) maxResults() int {
+	if o.MaxResults > 0 {
+		return o.MaxResults
+	}
+	return maxSearchMatches
+}
 
-// GetTreeLazy returns a file tree with lazy loading support
-func GetTreeLazy(rootPath string, options TreeOptions) ([]*FileNode, error) {
+// GetTreeLazy returns a file tree with lazy loading support, plus a cursor
+// for the next page of the root directory if it was truncated
+func GetTreeLazy(rootPath string, options TreeOptions) ([]*FileNode, string, error) {
 	if rootPath == "" {
 		rootPath = "/"
 	}
 
 	// Normalize root path
-	rootPath = filepath.Clean(rootPath)
+	rootPath = CleanRoot(rootPath)
 
 	// Use default options if none provided
 	if options.MaxDepth == 0 {
@@ -117,124 +159,91 @@ func GetTreeLazy(rootPath string, options TreeOptions) ([]*FileNode, error) {
 		options.RootPath = rootPath
 	}
 
-	return getDirectoryContents(rootPath, "", options, 0)
+	backend, err := Open(options.RootPath)
+	if err != nil {
+		return nil, "", err
+	}
+	ignore := newIgnorer(backend, options.SkipPatterns)
+	return getDirectoryContents(backend, ignore, "", options, 0)
 }
 
-// getDirectoryContents gets contents of a directory with lazy loading
-func getDirectoryContents(dirPath, relPath string, options TreeOptions, currentDepth int) ([]*FileNode, error) {
-	fullPath := filepath.Join(options.RootPath, relPath)
-
+// getDirectoryContents gets one page of a directory with lazy loading. The
+// returned cursor is empty when the listing is complete.
+func getDirectoryContents(backend Backend, ignore *Ignorer, relPath string, options TreeOptions, currentDepth int) ([]*FileNode, string, error) {
 	// Read directory contents
-	entries, err := os.ReadDir(fullPath)
+	entries, err := backend.ReadDir(relPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	// Separate folders and files for sorting
-	var folders []os.DirEntry
-	var files []os.DirEntry
-
+	// Drop ignored entries before paging so every page is full
+	visible := entries[:0:0]
+	ignoredEntries := make(map[string]bool)
 	for _, entry := range entries {
-		if entry.IsDir() {
-			folders = append(folders, entry)
-		} else {
-			files = append(files, entry)
+		if ignore.Ignored(joinName(relPath, entry.Name()), entry.IsDir()) {
+			if !options.ShowIgnored {
+				continue
+			}
+			ignoredEntries[entry.Name()] = true
 		}
+		visible = append(visible, entry)
 	}
 
-	// Sort folders and files alphabetically
-	sort.Slice(folders, func(i, j int) bool {
-		return folders[i].Name() < folders[j].Name()
-	})
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name() < files[j].Name()
-	})
-
-	// Combine: folders first, then files
-	var sortedEntries []os.DirEntry
-	sortedEntries = append(sortedEntries, folders...)
-	sortedEntries = append(sortedEntries, files...)
+	sortEntries(visible, options)
+	page, nextCursor, err := pageEntries(visible, options)
+	if err != nil {
+		return nil, "", err
+	}
 
 	var nodes []*FileNode = make([]*FileNode, 0) // Initialize as empty slice instead of nil
-	fileCount := 0
-
-	for _, entry := range sortedEntries {
-		// Skip hidden files and directories
-		// if strings.HasPrefix(entry.Name(), ".") && entry.Name() != "." && entry.Name() != ".." {
-		// 	continue
-		// }
-
-		// Skip filtered directories
-		if entry.IsDir() && skipDirs[entry.Name()] {
-			continue
-		}
 
+	for _, entry := range page {
 		// Build relative path
-		entryRelPath := filepath.Join(relPath, entry.Name())
-		if relPath == "" {
-			entryRelPath = entry.Name()
-		}
+		entryRelPath := joinName(relPath, entry.Name())
+		ignored := ignoredEntries[entry.Name()]
 
 		// Build normalized path
-		normalizedPath := "/" + filepath.ToSlash(entryRelPath)
+		normalizedPath := "/" + entryRelPath
 
 		node := &FileNode{
-			Name:   entry.Name(),
-			Path:   normalizedPath,
-			Loaded: false, // Children not loaded yet
+			Name:    entry.Name(),
+			Path:    normalizedPath,
+			Loaded:  false, // Children not loaded yet
+			Ignored: ignored,
 		}
 
 		if entry.IsDir() {
 			node.Type = "folder"
 
 			// Check if directory has children (but don't load them yet)
-			subPath := filepath.Join(fullPath, entry.Name())
-			subEntries, err := os.ReadDir(subPath)
-			if err == nil && len(subEntries) > 0 {
-				// Count visible children (consistent with main iteration loop)
-				visibleChildren := 0
+			subEntries, err := backend.ReadDir(entryRelPath)
+			if err == nil {
 				for _, subEntry := range subEntries {
-					if subEntry.IsDir() && skipDirs[subEntry.Name()] {
-						continue
+					if options.ShowIgnored || !ignore.Ignored(joinName(entryRelPath, subEntry.Name()), subEntry.IsDir()) {
+						node.HasMore = true
+						break
 					}
-					visibleChildren++
-				}
-
-				node.HasMore = visibleChildren > 0
-				if visibleChildren > options.MaxFiles {
-					node.HasMore = true
 				}
 			}
 		} else {
 			node.Type = "file"
-			fileCount++
-		}
-
-		// Respect max files limit
-		if fileCount >= options.MaxFiles && !entry.IsDir() {
-			// Add a "more files" indicator
-			nodes = append(nodes, &FileNode{
-				Name:   "...",
-				Type:   "file",
-				Path:   normalizedPath + "/more",
-				Loaded: true,
-			})
-			break
+			node.Archive = isArchiveName(entry.Name())
 		}
 
 		nodes = append(nodes, node)
 
-		// Load children if within depth limit and directory is small
-		if entry.IsDir() && currentDepth < options.MaxDepth {
+		// Load children if within depth limit and directory is small;
+		// ignored folders are only loaded when expanded
+		if entry.IsDir() && node.HasMore && !ignored && currentDepth < options.MaxDepth {
 			// For immediate children, load a limited set
-			childOptions := TreeOptions{
-				MaxDepth: 1,  // Only load one level deeper
-				MaxFiles: 10, // Limit to 10 items for preview
-				RootPath: options.RootPath,
-			}
-
-			children, err := getDirectoryContents(
-				filepath.Join(dirPath, entry.Name()),
+			childOptions := options
+			childOptions.MaxDepth = 1  // Only load one level deeper
+			childOptions.MaxFiles = 10 // Limit to 10 items for preview
+			childOptions.Cursor = ""
+
+			children, childCursor, err := getDirectoryContents(
+				backend,
+				ignore,
 				entryRelPath,
 				childOptions,
 				currentDepth+1,
@@ -242,27 +251,33 @@ func getDirectoryContents(dirPath, relPath string, options TreeOptions, currentD
 			if err == nil && len(children) > 0 {
 				node.Children = children
 				node.Loaded = true
+				node.NextCursor = childCursor
 			}
 		}
 	}
 
-	return nodes, nil
+	return nodes, nextCursor, nil
 }
 
-// GetDirectoryContents gets contents of a specific directory (for lazy loading)
-func GetDirectoryContents(dirPath string, rootPath string) ([]*FileNode, error) {
-	options := TreeOptions{
-		MaxDepth: 1, // Only immediate children
-		MaxFiles: 100,
-		RootPath: rootPath,
+// GetDirectoryContents gets a page of a specific directory (for lazy loading)
+func GetDirectoryContents(dirPath string, rootPath string, options TreeOptions) ([]*FileNode, string, error) {
+	options.MaxDepth = 1 // Only immediate children
+	if options.MaxFiles == 0 {
+		options.MaxFiles = 100
 	}
+	options.RootPath = rootPath
 
-	relPath, err := filepath.Rel(rootPath, dirPath)
+	backend, relPath, err := resolve(dirPath, rootPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if archive, inner, ok := splitArchivePath(relPath); ok {
+		nodes, err := archiveDirectoryContents(backend, archive, inner)
+		return nodes, "", err
 	}
 
-	return getDirectoryContents(dirPath, relPath, options, 0)
+	ignore := newIgnorer(backend, options.SkipPatterns)
+	return getDirectoryContents(backend, ignore, relPath, options, 0)
 }
 
 // GetTree returns the full tree (legacy function for backward compatibility)
@@ -273,17 +288,41 @@ func GetTree(rootPath string) ([]*FileNode, error) {
 		RootPath: rootPath,
 	}
 
-	return GetTreeLazy(rootPath, options)
+	tree, _, err := GetTreeLazy(rootPath, options)
+	return tree, err
+}
+
+// Stat returns file info for a path in the workspace
+func Stat(filePath, rootPath string) (fs.FileInfo, error) {
+	backend, p, err := resolve(filePath, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if archive, inner, ok := splitArchivePath(p); ok {
+		idx, err := openArchive(backend, archive)
+		if err != nil {
+			return nil, err
+		}
+		return idx.stat(inner)
+	}
+	return backend.Stat(p)
 }
 
 // ReadFile reads actual file content
 func ReadFile(filePath, rootPath string) (string, error) {
-	p, err := cleanAndValidatePath(filePath)
+	backend, p, err := resolve(filePath, rootPath)
 	if err != nil {
 		return "", err
 	}
-	fullPath := filepath.Join(rootPath, p)
-	content, err := os.ReadFile(fullPath)
+	if archive, inner, ok := splitArchivePath(p); ok {
+		idx, err := openArchive(backend, archive)
+		if err != nil {
+			return "", err
+		}
+		content, err := idx.readFile(inner)
+		return string(content), err
+	}
+	content, err := backend.ReadFile(p)
 	if err != nil {
 		return "", err
 	}
@@ -292,232 +331,137 @@ func ReadFile(filePath, rootPath string) (string, error) {
 
 // WriteFile writes to actual file
 func WriteFile(filePath, rootPath, content string) error {
-	p, err := cleanAndValidatePath(filePath)
+	backend, p, err := resolveWritable(filePath, rootPath)
 	if err != nil {
 		return err
 	}
-	fullPath := filepath.Join(rootPath, p)
 
 	// Ensure directory exists
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := backend.MkdirAll(path.Dir(p), 0755); err != nil {
 		return err
 	}
 
-	return os.WriteFile(fullPath, []byte(content), 0644)
+	return backend.WriteFile(p, []byte(content), 0644)
 }
 
 // DeleteFile deletes actual file or directory
 func DeleteFile(filePath, rootPath string) error {
-	p, err := cleanAndValidatePath(filePath)
+	backend, p, err := resolveWritable(filePath, rootPath)
 	if err != nil {
 		return err
 	}
-	fullPath := filepath.Join(rootPath, p)
-	return os.RemoveAll(fullPath)
+	return backend.RemoveAll(p)
 }
 
 // CreateFile creates a new file
 func CreateFile(filePath, rootPath string) error {
-	p, err := cleanAndValidatePath(filePath)
+	backend, p, err := resolveWritable(filePath, rootPath)
 	if err != nil {
 		return err
 	}
-	fullPath := filepath.Join(rootPath, p)
 
 	// Ensure directory exists
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	file, err := os.Create(fullPath)
-	if err != nil {
+	if err := backend.MkdirAll(path.Dir(p), 0755); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	return nil
+	return backend.WriteFile(p, nil, 0644)
 }
 
 // CreateDirectory creates a new directory
 func CreateDirectory(dirPath, rootPath string) error {
-	p, err := cleanAndValidatePath(dirPath)
+	backend, p, err := resolveWritable(dirPath, rootPath)
 	if err != nil {
 		return err
 	}
-	fullPath := filepath.Join(rootPath, p)
 
-	return os.MkdirAll(fullPath, 0755)
+	return backend.MkdirAll(p, 0755)
 }
 
-// RenameFile renames a file or directory
+// RenameFile renames a file or directory, creating the destination's parent
+// directories as needed
 func RenameFile(oldPath, newPath, rootPath string) error {
-	pOld, err := cleanAndValidatePath(oldPath)
+	backend, pOld, err := resolveWritable(oldPath, rootPath)
 	if err != nil {
 		return err
 	}
-	pNew, err := cleanAndValidatePath(newPath)
+	_, pNew, err := resolveWritable(newPath, rootPath)
 	if err != nil {
 		return err
 	}
-	oldFullPath := filepath.Join(rootPath, pOld)
-	newFullPath := filepath.Join(rootPath, pNew)
-	return os.Rename(oldFullPath, newFullPath)
+
+	// Ensure the new directory exists
+	if err := backend.MkdirAll(path.Dir(pNew), 0755); err != nil {
+		return err
+	}
+	return backend.Rename(pOld, pNew)
 }
 
 // Copy copies a file or directory recursively
 func Copy(srcPath, dstPath, rootPath string) error {
-	srcP, err := cleanAndValidatePath(srcPath)
+	backend, srcP, err := resolve(srcPath, rootPath)
 	if err != nil {
 		return err
 	}
-	dstP, err := cleanAndValidatePath(dstPath)
+	_, dstP, err := resolveWritable(dstPath, rootPath)
 	if err != nil {
 		return err
 	}
+	if archive, inner, ok := splitArchivePath(srcP); ok {
+		return copyFromArchive(backend, archive, inner, dstP)
+	}
 
-	fullSrc := filepath.Join(rootPath, srcP)
-	fullDst := filepath.Join(rootPath, dstP)
-
-	info, err := os.Stat(fullSrc)
+	info, err := backend.Stat(srcP)
 	if err != nil {
 		return err
 	}
 
 	if info.IsDir() {
-		return copyDir(fullSrc, fullDst)
+		return copyDir(backend, srcP, dstP)
 	}
-	return copyFile(fullSrc, fullDst)
+	return copyFile(backend, srcP, dstP, info.Mode())
 }
 
-// SearchWorkspace searches text files below rootPath.
-func SearchWorkspace(rootPath string, options SearchOptions) (SearchResult, error) {
-	var result SearchResult
-	matcher, err := buildSearchRegexp(options)
-	if err != nil {
-		return result, err
-	}
-
-	err = filepath.WalkDir(rootPath, func(path string, entry os.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if path == rootPath {
-			return nil
-		}
-
-		name := entry.Name()
-		if entry.IsDir() {
-			if skipDirs[name] {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		relPath, err := filepath.Rel(rootPath, path)
-		if err != nil {
-			return nil
-		}
-		if !matchesSearchFilters(filepath.ToSlash(relPath), options) {
-			return nil
-		}
-
-		info, err := entry.Info()
-		if err != nil || info.Size() > maxSearchFileSize {
-			return nil
-		}
-
-		content, err := os.ReadFile(path)
-		if err != nil || !utf8.Valid(content) || hasNulByte(content) {
-			return nil
-		}
-
-		fileResult := searchFileContent(path, rootPath, string(content), matcher)
-		if len(fileResult.Matches) == 0 {
-			return nil
-		}
-
-		for _, match := range fileResult.Matches {
-			if result.Matches >= maxSearchMatches {
-				result.LimitHit = true
-				return filepath.SkipAll
-			}
-			if len(result.Files) == 0 || result.Files[len(result.Files)-1].Path != fileResult.Path {
-				result.Files = append(result.Files, SearchFileResult{Path: fileResult.Path})
-			}
-			last := &result.Files[len(result.Files)-1]
-			last.Matches = append(last.Matches, match)
-			result.Matches++
-		}
-		return nil
-	})
-
-	return result, err
+// Stamp is the metadata compared between scans to detect changes by polling.
+type Stamp struct {
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
 }
 
-// ReplaceWorkspace replaces search matches in text files below rootPath.
-func ReplaceWorkspace(rootPath string, options SearchOptions) (ReplaceResult, error) {
-	var result ReplaceResult
-	matcher, err := buildSearchRegexp(options)
+// Scan stamps every entry up to maxDepth directory levels below dir, a
+// folder of rootPath ("" for the root itself).
+func Scan(rootPath, dir string, maxDepth int) (map[string]Stamp, error) {
+	backend, err := Open(rootPath)
 	if err != nil {
-		return result, err
+		return nil, err
+	}
+	dir = strings.Trim(dir, "/")
+	baseDepth := 0
+	if dir != "" {
+		baseDepth = strings.Count(dir, "/") + 1
 	}
 
-	err = filepath.WalkDir(rootPath, func(path string, entry os.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if path == rootPath {
-			return nil
-		}
-
-		name := entry.Name()
-		if entry.IsDir() {
-			if skipDirs[name] {
-				return filepath.SkipDir
+	ignore := newIgnorer(backend, nil)
+	stamps := make(map[string]Stamp)
+	err = walkBackend(backend, dir, func(name string, entry fs.DirEntry) error {
+		if ignore.Ignored(name, entry.IsDir()) {
+			if entry.IsDir() {
+				return fs.SkipDir
 			}
 			return nil
 		}
-
-		relPath, err := filepath.Rel(rootPath, path)
-		if err != nil {
-			return nil
-		}
-		if !matchesSearchFilters(filepath.ToSlash(relPath), options) {
-			return nil
-		}
-
 		info, err := entry.Info()
-		if err != nil || info.Size() > maxSearchFileSize {
-			return nil
-		}
-
-		content, err := os.ReadFile(path)
-		if err != nil || !utf8.Valid(content) || hasNulByte(content) {
-			return nil
-		}
-
-		matches := matcher.FindAllIndex(content, -1)
-		if len(matches) == 0 {
+		if err != nil {
 			return nil
 		}
-
-		replaced := matcher.ReplaceAllLiteralString(string(content), options.Replace)
-		if options.UseRegex {
-			replaced = matcher.ReplaceAllString(string(content), options.Replace)
-		}
-		if err := os.WriteFile(path, []byte(replaced), info.Mode()); err != nil {
-			return err
+		stamps[name] = Stamp{Size: info.Size(), ModTime: info.ModTime(), IsDir: entry.IsDir()}
+		if entry.IsDir() && strings.Count(name, "/")-baseDepth >= maxDepth {
+			return fs.SkipDir
 		}
-
-		result.Files++
-		result.Replacements += len(matches)
-		result.Paths = append(result.Paths, "/"+filepath.ToSlash(relPath))
 		return nil
 	})
-
-	return result, err
+	return stamps, err
 }
 
 func buildSearchRegexp(options SearchOptions) (*regexp.Regexp, error) {
@@ -531,102 +475,43 @@ func buildSearchRegexp(options SearchOptions) (*regexp.Regexp, error) {
 	if !options.CaseSensitive {
 		pattern = `(?i)` + pattern
 	}
-	return regexp.Compile(pattern)
-}
-
-func matchesSearchFilters(relPath string, options SearchOptions) bool {
-	path := strings.TrimPrefix(relPath, "/")
-	if options.Include != "" && !pathMatchesAnyToken(path, options.Include) {
-		return false
-	}
-	if options.Exclude != "" && pathMatchesAnyToken(path, options.Exclude) {
-		return false
+	if options.Multiline {
+		pattern = `(?m)` + pattern
 	}
-	return true
-}
-
-func pathMatchesAnyToken(path, filter string) bool {
-	for _, token := range strings.Split(filter, ",") {
-		token = strings.TrimSpace(token)
-		if token == "" {
-			continue
-		}
-		normalized := strings.Trim(strings.ReplaceAll(token, "\\", "/"), "/")
-		if wildcardMatchesPath(path, normalized) {
-			return true
-		}
-	}
-	return false
+	return regexp.Compile(pattern)
 }
 
-func wildcardMatchesPath(path, pattern string) bool {
-	if pattern == "" {
-		return false
-	}
-	if !strings.ContainsAny(pattern, "*?") {
-		return strings.Contains(path, pattern)
-	}
-
-	targets := []string{path}
-	if !strings.Contains(pattern, "/") {
-		targets = append(targets, filepath.Base(path))
-	}
-
-	re, err := regexp.Compile("^" + globToRegexp(pattern) + "$")
-	if err != nil {
-		return false
-	}
-	for _, target := range targets {
-		if re.MatchString(target) {
-			return true
-		}
+func searchFileContent(name, content string, matcher *regexp.Regexp, options SearchOptions) SearchFileResult {
+	fileResult := SearchFileResult{Path: "/" + name}
+	lines := strings.SplitAfter(content, "\n")
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimRight(line, "\r\n")
 	}
-	return false
-}
-
-func globToRegexp(pattern string) string {
-	var builder strings.Builder
-	for i := 0; i < len(pattern); i++ {
-		switch pattern[i] {
-		case '*':
-			if i+1 < len(pattern) && pattern[i+1] == '*' {
-				builder.WriteString(".*")
-				i++
-			} else {
-				builder.WriteString(`[^/]*`)
-			}
-		case '?':
-			builder.WriteString(`[^/]`)
-		default:
-			builder.WriteString(regexp.QuoteMeta(string(pattern[i])))
-		}
+	if options.Multiline {
+		fileResult.Matches = searchMultiline(content, lines, trimmed, matcher, options)
+		return fileResult
 	}
-	return builder.String()
-}
 
-func searchFileContent(path, rootPath, content string, matcher *regexp.Regexp) SearchFileResult {
-	relPath, err := filepath.Rel(rootPath, path)
-	if err != nil {
-		relPath = path
-	}
-	fileResult := SearchFileResult{Path: "/" + filepath.ToSlash(relPath)}
-	lines := strings.SplitAfter(content, "\n")
 	offset := 0
-
 	for lineIndex, line := range lines {
-		lineWithoutBreak := strings.TrimRight(line, "\r\n")
+		lineWithoutBreak := trimmed[lineIndex]
 		lineStart := offset
 		matches := matcher.FindAllStringIndex(lineWithoutBreak, -1)
 		for _, match := range matches {
 			start := lineStart + match[0]
 			end := lineStart + match[1]
+			before, after := searchContext(trimmed, lineIndex, lineIndex, options)
 			fileResult.Matches = append(fileResult.Matches, SearchMatch{
 				Line:          lineIndex + 1,
 				Column:        utf8.RuneCountInString(content[lineStart:start]) + 1,
+				EndLine:       lineIndex + 1,
 				EndColumn:     utf8.RuneCountInString(content[lineStart:end]) + 1,
 				LineText:      lineWithoutBreak,
 				PreviewStart:  utf8.RuneCountInString(content[lineStart:start]),
 				PreviewLength: utf8.RuneCountInString(content[start:end]),
+				Before:        before,
+				After:         after,
 			})
 		}
 		offset += len(line)
@@ -635,11 +520,86 @@ func searchFileContent(path, rootPath, content string, matcher *regexp.Regexp) S
 	return fileResult
 }
 
+// searchMultiline matches against the whole content. A match that ends with
+// a line break ends on the line the break belongs to.
+func searchMultiline(content string, lines, trimmed []string, matcher *regexp.Regexp, options SearchOptions) []SearchMatch {
+	lineStarts := make([]int, len(lines)+1)
+	for i, line := range lines {
+		lineStarts[i+1] = lineStarts[i] + len(line)
+	}
+	lineOf := func(offset int) int {
+		return min(sort.Search(len(lines), func(i int) bool { return lineStarts[i+1] > offset }), len(lines)-1)
+	}
+
+	var matches []SearchMatch
+	for _, loc := range matcher.FindAllStringIndex(content, -1) {
+		first := lineOf(loc[0])
+		last := first
+		if loc[1] > loc[0] {
+			last = lineOf(loc[1] - 1)
+		}
+		textStart := lineStarts[first]
+		textEnd := lineStarts[last] + len(trimmed[last])
+		start := min(loc[0], textEnd)
+		end := min(loc[1], textEnd)
+
+		before, after := searchContext(trimmed, first, last, options)
+		matches = append(matches, SearchMatch{
+			Line:          first + 1,
+			Column:        utf8.RuneCountInString(content[textStart:loc[0]]) + 1,
+			EndLine:       last + 1,
+			EndColumn:     utf8.RuneCountInString(content[lineStarts[last]:end]) + 1,
+			LineText:      content[textStart:textEnd],
+			PreviewStart:  utf8.RuneCountInString(content[textStart:start]),
+			PreviewLength: utf8.RuneCountInString(content[start:end]),
+			Before:        before,
+			After:         after,
+		})
+	}
+	return matches
+}
+
+// searchContext returns the lines around lines[first:last+1], like grep -C.
+func searchContext(lines []string, first, last int, options SearchOptions) ([]string, []string) {
+	// The empty string after a final line break isn't a line
+	if n := len(lines); n > 1 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+
+	var before, after []string
+	for i := max(first-min(options.Before, maxSearchContext), 0); i < first; i++ {
+		before = append(before, lines[i])
+	}
+	for i := last + 1; i < len(lines) && i <= last+min(options.After, maxSearchContext); i++ {
+		after = append(after, lines[i])
+	}
+	return before, after
+}
+
 func hasNulByte(content []byte) bool {
 	return bytes.IndexByte(content, 0) >= 0
 }
 
-func copyFile(src, dst string) error {
+func copyFile(backend Backend, src, dst string, perm fs.FileMode) error {
+	if local, ok := backend.(*LocalBackend); ok {
+		return copyLocalFile(local.Path(src), local.Path(dst))
+	}
+
+	content, err := backend.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if err := backend.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	return backend.WriteFile(dst, content, perm)
+}
+
+// copyLocalFile streams a file on local disk, so large files aren't read
+// into memory.
+func copyLocalFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
@@ -656,32 +616,36 @@ func copyFile(src, dst string) error {
 	}
 	defer out.Close()
 
-	if _, err = in.WriteTo(out); err != nil {
+	if _, err = io.Copy(out, in); err != nil {
 		return err
 	}
 	return out.Sync()
 }
 
-func copyDir(src, dst string) error {
-	if err := os.MkdirAll(dst, 0755); err != nil {
+func copyDir(backend Backend, src, dst string) error {
+	if err := backend.MkdirAll(dst, 0755); err != nil {
 		return err
 	}
 
-	entries, err := os.ReadDir(src)
+	entries, err := backend.ReadDir(src)
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
+		srcPath := joinName(src, entry.Name())
+		dstPath := joinName(dst, entry.Name())
 
 		if entry.IsDir() {
-			if err := copyDir(srcPath, dstPath); err != nil {
+			if err := copyDir(backend, srcPath, dstPath); err != nil {
 				return err
 			}
 		} else {
-			if err := copyFile(srcPath, dstPath); err != nil {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := copyFile(backend, srcPath, dstPath, info.Mode()); err != nil {
 				return err
 			}
 		}
@@ -689,10 +653,35 @@ func copyDir(src, dst string) error {
 	return nil
 }
 
+// resolve opens the workspace backend and validates a workspace path
+func resolve(filePath, rootPath string) (Backend, string, error) {
+	p, err := cleanAndValidatePath(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	backend, err := Open(rootPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return backend, p, nil
+}
+
+// resolveWritable is resolve for operations that modify the path
+func resolveWritable(filePath, rootPath string) (Backend, string, error) {
+	backend, p, err := resolve(filePath, rootPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, _, ok := splitArchivePath(p); ok {
+		return nil, "", ErrReadOnly
+	}
+	return backend, p, nil
+}
+
 // cleanAndValidatePath cleans and validates a path
 func cleanAndValidatePath(p string) (string, error) {
 	p = strings.TrimPrefix(p, "/")
-	p = filepath.Clean(p)
+	p = filepath.ToSlash(filepath.Clean(p))
 	if p == "." || p == "" {
 		return "", nil
 	}
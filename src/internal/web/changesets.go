@@ -0,0 +1,47 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"lite-ide/internal/vfs"
+	"net/http"
+	"strings"
+)
+
+// handleChangesets lists recorded replaces (GET /changesets) and reverts
+// one (POST /changesets/{id}/revert)
+func handleChangesets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	rootPath := workspaceRoot(r)
+
+	if r.URL.Path == "/changesets" {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(vfs.ListChangesets(rootPath))
+		return
+	}
+
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/changesets/"), "/revert")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := vfs.RevertChangeset(rootPath, id, r.URL.Query().Get("force") == "true")
+	if errors.Is(err, vfs.ErrChangesetNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), writeErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
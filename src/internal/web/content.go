@@ -0,0 +1,223 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"lite-ide/internal/vfs"
+	"net/http"
+	"path"
+	"unicode/utf8"
+)
+
+// maxNotifyContent is the largest file whose content or diff is sent with a
+// contentChanged event
+const maxNotifyContent = 256 * 1024
+
+// contentChange is sent as a contentChanged event to clients subscribed to
+// a file whose content changed
+type contentChange struct {
+	Path string `json:"path"`
+	vfs.FileVersion
+	Content *string `json:"content,omitempty"` // With "content", for small text files
+	Diff    string  `json:"diff,omitempty"`    // With "diff", against the content last sent
+}
+
+// fileSubscription is a file a client has open, e.g. in an editor tab
+type fileSubscription struct {
+	content bool
+	diff    bool
+	hash    string // Version the client has
+	known   []byte // Content the client has, kept for diffs
+}
+
+// fileContent is a subscribed file as read after a change
+type fileContent struct {
+	content []byte
+	version vfs.FileVersion
+}
+
+// subscribeFile registers a client's interest in a file's content. The
+// file's folder is watched while any client is subscribed. If hash is given
+// and the file no longer matches it, the change is sent right away.
+func (h *watchHub) subscribeFile(clientID, name string, content, diff bool, hash string) error {
+	data, version, err := vfs.ReadFileVersion(name, h.root)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client, ok := h.clients[clientID]
+	if !ok {
+		return errUnknownWatchClient
+	}
+	if client.files == nil {
+		client.files = make(map[string]*fileSubscription)
+	}
+	if _, ok := client.files[name]; !ok {
+		h.holdScope(watchScope{watchFolderName(path.Dir(name)), 0})
+	}
+	subscription := &fileSubscription{content: content, diff: diff, hash: hash}
+	if hash == "" {
+		subscription.hash = version.Hash
+	}
+	if diff && subscription.hash == version.Hash {
+		subscription.known = data
+	}
+	client.files[name] = subscription
+
+	if change, ok := subscription.update(name, &fileContent{data, version}); ok {
+		client.send(watchUpdate{contents: []contentChange{change}})
+	}
+	return nil
+}
+
+// unsubscribeFile drops a client's interest in a file's content.
+func (h *watchHub) unsubscribeFile(clientID, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client, ok := h.clients[clientID]
+	if !ok {
+		return errUnknownWatchClient
+	}
+	if _, ok := client.files[name]; ok {
+		delete(client.files, name)
+		h.releaseScope(watchScope{watchFolderName(path.Dir(name)), 0})
+	}
+	return nil
+}
+
+// readSubscribed reads the subscribed files an update may have changed:
+// the ones it names, or all of them for a resync.
+func (h *watchHub) readSubscribed(update watchUpdate) map[string]*fileContent {
+	h.mu.Lock()
+	subscribed := make(map[string]bool)
+	for _, client := range h.clients {
+		for name := range client.files {
+			subscribed[name] = true
+		}
+	}
+	h.mu.Unlock()
+
+	var names []string
+	if update.resync {
+		for name := range subscribed {
+			names = append(names, name)
+		}
+	} else {
+		for _, event := range update.events {
+			if event.Type != "deleted" && subscribed[event.Path] {
+				names = append(names, event.Path)
+			}
+		}
+	}
+
+	files := make(map[string]*fileContent, len(names))
+	for _, name := range names {
+		if content, version, err := vfs.ReadFileVersion(name, h.root); err == nil {
+			files[name] = &fileContent{content, version}
+		}
+	}
+	return files
+}
+
+// recheckFiles returns the changes a client missed in its subscribed files,
+// after its queued updates were dropped.
+func (h *watchHub) recheckFiles(client *watchClient) []contentChange {
+	h.mu.Lock()
+	names := make([]string, 0, len(client.files))
+	for name := range client.files {
+		names = append(names, name)
+	}
+	h.mu.Unlock()
+
+	files := make(map[string]*fileContent, len(names))
+	for _, name := range names {
+		if content, version, err := vfs.ReadFileVersion(name, h.root); err == nil {
+			files[name] = &fileContent{content, version}
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return client.contentChanges(files)
+}
+
+// contentChanges returns the changes to send a client for the files read
+// after an update. Called with the hub's mu held.
+func (c *watchClient) contentChanges(files map[string]*fileContent) []contentChange {
+	var changes []contentChange
+	for name, file := range files {
+		if subscription, ok := c.files[name]; ok {
+			if change, ok := subscription.update(name, file); ok {
+				changes = append(changes, change)
+			}
+		}
+	}
+	return changes
+}
+
+// update records that the client is sent file's version, returning the
+// change to send unless the client already has it.
+func (s *fileSubscription) update(name string, file *fileContent) (contentChange, bool) {
+	if file.version.Hash == s.hash {
+		return contentChange{}, false
+	}
+	change := contentChange{Path: name, FileVersion: file.version}
+	small := len(file.content) <= maxNotifyContent && utf8.Valid(file.content)
+	if s.content && small {
+		text := string(file.content)
+		change.Content = &text
+	}
+	if s.diff && small && s.known != nil {
+		change.Diff = vfs.DiffContent(name, s.known, file.content)
+	}
+	s.hash = file.version.Hash
+	s.known = nil
+	if s.diff && small {
+		s.known = file.content
+	}
+	return change, true
+}
+
+// handleFileSubscription subscribes a watch client to a file's content
+// (POST /subscribe) or unsubscribes it (POST /unsubscribe).
+func handleFileSubscription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	var req struct {
+		Path    string `json:"path"`
+		Client  string `json:"client"`
+		Hash    string `json:"hash"`
+		Content bool   `json:"content"`
+		Diff    bool   `json:"diff"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hub, ok := watchHubForClient(req.Client)
+	if !ok {
+		http.Error(w, errUnknownWatchClient.Error(), http.StatusNotFound)
+		return
+	}
+	name := "/" + watchFolderName(req.Path)
+	action := "Subscribed"
+	var err error
+	if r.URL.Path == "/subscribe" {
+		err = hub.subscribeFile(req.Client, name, req.Content, req.Diff, req.Hash)
+	} else {
+		action = "Unsubscribed"
+		err = hub.unsubscribeFile(req.Client, name)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	response := map[string]string{
+		"status":  "success",
+		"message": fmt.Sprintf("%s: %s", action, name),
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -3,6 +3,7 @@ package web
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -10,11 +11,8 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
-
-	"github.com/fsnotify/fsnotify"
 )
 
 //go:embed build/*
@@ -34,11 +32,28 @@ func Handlers() (api http.Handler, web http.Handler) {
 	return
 }
 
+// workspaceRoot returns the workspace a request is for: ?root=, or the
+// working directory when it is empty or ".". The root is normalized with
+// vfs.CleanRoot, so every spelling of a workspace shares one watch hub,
+// index and changeset history.
+func workspaceRoot(r *http.Request) string {
+	rootPath := r.URL.Query().Get("root")
+	if rootPath == "" || rootPath == "." {
+		if cwd, err := os.Getwd(); err == nil {
+			rootPath = cwd
+		} else {
+			rootPath = "."
+		}
+	}
+	return vfs.CleanRoot(rootPath)
+}
+
 func apiHandler(w http.ResponseWriter, r *http.Request) {
 	// CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, X-Content-Hash")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -62,6 +77,24 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Handle folder collapse, releasing its watches
+	if r.URL.Path == "/collapse" && r.Method == "POST" {
+		handleCollapseFolder(w, r)
+		return
+	}
+
+	// Handle content change subscriptions for open files
+	if (r.URL.Path == "/subscribe" || r.URL.Path == "/unsubscribe") && r.Method == "POST" {
+		handleFileSubscription(w, r)
+		return
+	}
+
+	// Handle file event hooks and their runs
+	if r.URL.Path == "/hooks" && r.Method == "GET" {
+		handleHooks(w, r)
+		return
+	}
+
 	// Handle copy operations
 	if r.URL.Path == "/copy" && r.Method == "POST" {
 		handleCopy(w, r)
@@ -74,6 +107,30 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Handle quick-open fuzzy file finding
+	if r.URL.Path == "/find" && r.Method == "GET" {
+		handleFind(w, r)
+		return
+	}
+
+	// Handle search index status and rebuilds
+	if r.URL.Path == "/index" && (r.Method == "GET" || r.Method == "POST") {
+		handleIndex(w, r)
+		return
+	}
+
+	// Handle replace history and reverts
+	if r.URL.Path == "/changesets" || strings.HasPrefix(r.URL.Path, "/changesets/") {
+		handleChangesets(w, r)
+		return
+	}
+
+	// Handle terminal session management
+	if r.URL.Path == "/terminals" || strings.HasPrefix(r.URL.Path, "/terminals/") {
+		handleTerminals(w, r)
+		return
+	}
+
 	// Handle file operations
 	switch r.Method {
 	case "GET":
@@ -91,104 +148,6 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleFileWatch(w http.ResponseWriter, r *http.Request) {
-	// Set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Get root path from query parameter or environment variable
-	root := r.URL.Query().Get("root")
-	if root == "" {
-		root = os.Getenv("NANO_IDE_ROOT")
-	}
-	if root == "" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			root = "."
-		} else {
-			root = cwd
-		}
-	}
-
-	// Create file watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		http.Error(w, "failed to create watcher", http.StatusInternalServerError)
-		return
-	}
-	defer watcher.Close()
-
-	// Add root directory and first 2 levels of subdirectories to watcher
-	err = addLazyWatch(watcher, root, 2)
-	if err != nil {
-		log.Printf("ERROR: addLazyWatch failed for root %s: %v", root, err)
-		errorMsg := fmt.Sprintf("Failed to setup file watching for %s: %v", root, err)
-		fmt.Fprintf(w, "event: error\ndata: %s\n\n", errorMsg)
-		w.(http.Flusher).Flush()
-		return
-	}
-
-	// Create a channel to signal client disconnection
-	clientGone := r.Context().Done()
-
-	// Send initial connection event
-	fmt.Fprintf(w, "event: connected\ndata: connected\n\n")
-	w.(http.Flusher).Flush()
-
-	// Watch for events
-	var debounceTimer *time.Timer
-	sendTree := func() {
-		tree, err := vfs.GetTree(root)
-		if err != nil {
-			log.Printf("Failed to get tree after file change: %v", err)
-			return
-		}
-		treeData, _ := json.Marshal(tree)
-		fmt.Fprintf(w, "data: %s\n\n", treeData)
-		w.(http.Flusher).Flush()
-	}
-
-	for {
-		select {
-		case <-clientGone:
-			if debounceTimer != nil {
-				debounceTimer.Stop()
-			}
-			return
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return
-			}
-
-			// Skip temporary files and .git directory
-			if strings.Contains(event.Name, ".git") || strings.HasSuffix(event.Name, "~") {
-				continue
-			}
-
-			// Coalesce rapid events — send at most once per 200ms
-			if debounceTimer != nil {
-				debounceTimer.Stop()
-			}
-			debounceTimer = time.AfterFunc(200*time.Millisecond, sendTree)
-
-			// If new directory created, add it to watch
-			if event.Op&fsnotify.Create != 0 {
-				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
-					watcher.Add(event.Name)
-				}
-			}
-
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return
-			}
-			log.Printf("Watcher error: %v", err)
-		}
-	}
-}
-
 func handleExpandFolder(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -201,18 +160,13 @@ func handleExpandFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get root path from query parameter
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
-		}
-	}
+	rootPath := workspaceRoot(r)
 
 	// Parse request body
 	var req struct {
-		Path string `json:"path"`
+		Path   string `json:"path"`
+		Client string `json:"client"`
+		Depth  int    `json:"depth"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -220,8 +174,7 @@ func handleExpandFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify the path exists and is a directory
-	fullPath := filepath.Join(rootPath, req.Path)
-	info, err := os.Stat(fullPath)
+	info, err := vfs.Stat(req.Path, rootPath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -231,7 +184,20 @@ func handleExpandFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Return success — the SSE watcher in handleFileWatch will pick up changes
+	// Watch the folder for the client's watch stream while it stays expanded
+	if req.Client != "" {
+		hub, ok := watchHubForClient(req.Client)
+		if !ok {
+			http.Error(w, errUnknownWatchClient.Error(), http.StatusNotFound)
+			return
+		}
+		depth := min(max(req.Depth, 1), maxExpandDepth)
+		if err := hub.expandFolder(req.Client, watchFolderName(req.Path), depth); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
 	response := map[string]string{
 		"status":  "success",
 		"message": fmt.Sprintf("Folder expanded: %s", req.Path),
@@ -239,104 +205,86 @@ func handleExpandFolder(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// addLazyWatch adds directories to watcher up to a specified depth
-func addLazyWatch(watcher *fsnotify.Watcher, root string, maxDepth int) error {
-	processed := make(map[string]bool)
-	watchCount := 0
-	maxWatches := 1000
-
-	var walkDir func(string, int) error
-	walkDir = func(path string, depth int) error {
-		if depth > maxDepth {
-			return nil
-		}
-
-		info, err := os.Stat(path)
-		if err != nil {
-			return nil
-		}
-
-		if !info.IsDir() {
-			return nil
-		}
-
-		base := filepath.Base(path)
-		if shouldSkipDirectory(base) {
-			return nil
-		}
-
-		if processed[path] {
-			return nil
-		}
-		processed[path] = true
-
-		if watchCount >= maxWatches {
-			log.Printf("Warning: reached maximum watch limit (%d)", maxWatches)
-			return nil
-		}
+func handleCollapseFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-		if err := watcher.Add(path); err != nil {
-			log.Printf("Warning: failed to add watch for %s: %v", path, err)
-			return nil
-		}
-		watchCount++
+	var req struct {
+		Path   string `json:"path"`
+		Client string `json:"client"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		// Read directory contents
-		entries, err := os.ReadDir(path)
-		if err != nil {
-			return nil
-		}
+	hub, ok := watchHubForClient(req.Client)
+	if !ok {
+		http.Error(w, errUnknownWatchClient.Error(), http.StatusNotFound)
+		return
+	}
+	if err := hub.collapseFolder(req.Client, watchFolderName(req.Path)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 
-		// Recursively process subdirectories
-		for _, entry := range entries {
-			if entry.IsDir() {
-				subPath := filepath.Join(path, entry.Name())
-				if err := walkDir(subPath, depth+1); err != nil {
-					return err
-				}
-			}
-		}
+	response := map[string]string{
+		"status":  "success",
+		"message": fmt.Sprintf("Folder collapsed: %s", req.Path),
+	}
+	json.NewEncoder(w).Encode(response)
+}
 
-		return nil
+// writeErrorStatus maps errors from write operations to a status code
+func writeErrorStatus(err error) int {
+	if errors.Is(err, vfs.ErrReadOnly) {
+		return http.StatusForbidden
 	}
+	return http.StatusInternalServerError
+}
 
-	return walkDir(root, 0)
+// treeOptions reads tree listing options from the query string
+func treeOptions(r *http.Request) vfs.TreeOptions {
+	options := vfs.TreeOptions{
+		MaxDepth:    2,
+		MaxFiles:    50,
+		ShowIgnored: r.URL.Query().Get("showIgnored") == "true",
+		Cursor:      r.URL.Query().Get("cursor"),
+		Sort:        r.URL.Query().Get("sort"),
+		MixFolders:  r.URL.Query().Get("foldersFirst") == "false",
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		options.MaxFiles = limit
+	}
+	return options
 }
 
-// shouldSkipDirectory determines if a directory should be skipped for file watching
-func shouldSkipDirectory(name string) bool {
-	skipDirs := map[string]bool{
-		".git":          true,
-		"node_modules":  true,
-		".next":         true,
-		".pnpm":         true,
-		"target":        true,
-		"__pycache__":   true,
-		".venv":         true,
-		"venv":          true,
-		".tox":          true,
-		"coverage":      true,
-		".pytest_cache": true,
-		".mypy_cache":   true,
-		".cache":        true,
-		".idea":         true,
-	}
-	return skipDirs[name]
+// writeTree encodes a directory page, passing the continuation cursor in
+// the X-Next-Cursor header
+func writeTree(w http.ResponseWriter, tree []*vfs.FileNode, nextCursor string, err error) {
+	if errors.Is(err, vfs.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if nextCursor != "" {
+		w.Header().Set("X-Next-Cursor", nextCursor)
+	}
+	// Ensure we return empty array instead of null for empty directories
+	if tree == nil {
+		tree = []*vfs.FileNode{}
+	}
+	json.NewEncoder(w).Encode(tree)
 }
 
 func handleGet(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 
 	// Get root path from query parameter
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		// Get current working directory
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
-		}
-	}
+	rootPath := workspaceRoot(r)
 
 	// Handle /files and /files/*
 	if strings.HasPrefix(r.URL.Path, "/files") {
@@ -350,6 +298,7 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			w.Header().Set("content-type", "text/plain")
+			w.Header().Set("X-Content-Hash", vfs.ContentHash([]byte(content)))
 			w.Write([]byte(content))
 			return
 		}
@@ -359,8 +308,7 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 			// Check if we want a specific subtree
 			if path := r.URL.Query().Get("path"); path != "" {
 				// Get subtree for lazy loading
-				fullPath := filepath.Join(rootPath, path)
-				info, err := os.Stat(fullPath)
+				info, err := vfs.Stat(path, rootPath)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusNotFound)
 					return
@@ -371,31 +319,18 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 
-				tree, err := vfs.GetDirectoryContents(fullPath, rootPath)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				// Ensure we return empty array instead of null for empty directories
-				if tree == nil {
-					tree = []*vfs.FileNode{}
+				options := treeOptions(r)
+				if r.URL.Query().Get("limit") == "" {
+					options.MaxFiles = 100
 				}
-				json.NewEncoder(w).Encode(tree)
+				tree, nextCursor, err := vfs.GetDirectoryContents(path, rootPath, options)
+				writeTree(w, tree, nextCursor, err)
 				return
 			}
 
 			// Default: get tree with lazy loading (limited depth)
-			tree, err := vfs.GetTree(rootPath)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			// Ensure we return empty array instead of null for empty directories
-			if tree == nil {
-				tree = []*vfs.FileNode{}
-			}
-			json.NewEncoder(w).Encode(tree)
+			tree, nextCursor, err := vfs.GetTreeLazy(rootPath, treeOptions(r))
+			writeTree(w, tree, nextCursor, err)
 			return
 		}
 	}
@@ -407,15 +342,7 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 
 	// Get root path from query parameter
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		// Get current working directory
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
-		}
-	}
+	rootPath := workspaceRoot(r)
 
 	// Handle /files and /files/ (with or without trailing slash)
 	if r.URL.Path == "/files" || r.URL.Path == "/files/" {
@@ -440,7 +367,7 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 
 		if err != nil {
 			log.Printf("[API] POST /files: failed to create %s %q (root %q): %v", req.Type, req.Path, rootPath, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), writeErrorStatus(err))
 			return
 		}
 		log.Printf("[API] POST /files: successfully created %s %q (root %q)", req.Type, req.Path, rootPath)
@@ -455,15 +382,7 @@ func handlePut(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 
 	// Get root path from query parameter
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		// Get current working directory
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
-		}
-	}
+	rootPath := workspaceRoot(r)
 
 	// Handle /files/*
 	if strings.HasPrefix(r.URL.Path, "/files") && len(r.URL.Path) > len("/files") && r.URL.Path[len("/files")] == '/' {
@@ -473,7 +392,7 @@ func handlePut(w http.ResponseWriter, r *http.Request) {
 		io.Copy(buf, r.Body)
 		err := vfs.WriteFile(path, rootPath, buf.String())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), writeErrorStatus(err))
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -495,15 +414,7 @@ func handlePatch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get root path from query parameter
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		// Get current working directory
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
-		}
-	}
+	rootPath := workspaceRoot(r)
 
 	// Handle /files/* for file renaming
 	if strings.HasPrefix(r.URL.Path, "/files") && len(r.URL.Path) > len("/files") && r.URL.Path[len("/files")] == '/' {
@@ -523,26 +434,14 @@ func handlePatch(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Perform the rename operation
-		oldFullPath := filepath.Join(rootPath, path)
-		newFullPath := filepath.Join(rootPath, req.NewPath)
-
-		// Ensure the new directory exists
-		newDir := filepath.Dir(newFullPath)
-		if err := os.MkdirAll(newDir, 0755); err != nil {
-			log.Printf("Failed to create directory %s: %v", newDir, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		// Perform the rename
-		if err := os.Rename(oldFullPath, newFullPath); err != nil {
-			log.Printf("Failed to rename %s to %s: %v", oldFullPath, newFullPath, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := vfs.RenameFile(path, req.NewPath, rootPath); err != nil {
+			log.Printf("Failed to rename %s to %s: %v", path, req.NewPath, err)
+			http.Error(w, err.Error(), writeErrorStatus(err))
 			return
 		}
 
-		log.Printf("Successfully renamed %s to %s", oldFullPath, newFullPath)
+		log.Printf("Successfully renamed %s to %s", path, req.NewPath)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -554,14 +453,7 @@ func handleCopy(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 
 	// Get root path from query parameter
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
-		}
-	}
+	rootPath := workspaceRoot(r)
 
 	// Parse request body for source and destination paths
 	var req struct {
@@ -581,7 +473,7 @@ func handleCopy(w http.ResponseWriter, r *http.Request) {
 	// Perform the copy operation
 	if err := vfs.Copy(req.Source, req.Destination, rootPath); err != nil {
 		log.Printf("Failed to copy %s to %s: %v", req.Source, req.Destination, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), writeErrorStatus(err))
 		return
 	}
 
@@ -592,29 +484,57 @@ func handleCopy(w http.ResponseWriter, r *http.Request) {
 func handleSearch(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
+	rootPath := workspaceRoot(r)
+
+	// Keep an existing (or auto-created) search index current
+	if vfs.IsLocal(rootPath) {
+		if status, err := vfs.GetIndexStatus(rootPath); err == nil && status.State != vfs.IndexNone {
+			watchIndexes(rootPath)
 		}
 	}
 
 	if r.Method == "GET" {
 		options := vfs.SearchOptions{
-			Query:         r.URL.Query().Get("q"),
-			Include:       r.URL.Query().Get("include"),
-			Exclude:       r.URL.Query().Get("exclude"),
-			CaseSensitive: r.URL.Query().Get("caseSensitive") == "true",
-			WholeWord:     r.URL.Query().Get("wholeWord") == "true",
-			UseRegex:      r.URL.Query().Get("regex") == "true",
+			Query:          r.URL.Query().Get("q"),
+			Include:        r.URL.Query().Get("include"),
+			Exclude:        r.URL.Query().Get("exclude"),
+			CaseSensitive:  r.URL.Query().Get("caseSensitive") == "true",
+			WholeWord:      r.URL.Query().Get("wholeWord") == "true",
+			UseRegex:       r.URL.Query().Get("regex") == "true",
+			Archives:       r.URL.Query().Get("archives") == "true",
+			Multiline:      r.URL.Query().Get("multiline") == "true",
+			FollowSymlinks: r.URL.Query().Get("followSymlinks") == "true",
+			Encoding:       r.URL.Query().Get("encoding"),
+		}
+		if n, err := strconv.ParseInt(r.URL.Query().Get("maxFileSize"), 10, 64); err == nil && n > 0 {
+			options.MaxFileSize = n
+		}
+		if n, err := strconv.Atoi(r.URL.Query().Get("maxResults")); err == nil && n > 0 {
+			options.MaxResults = n
+		}
+		if n, err := strconv.Atoi(r.URL.Query().Get("maxPerFile")); err == nil && n > 0 {
+			options.MaxPerFile = n
+		}
+		// context sets both sides, like grep -C
+		if n, err := strconv.Atoi(r.URL.Query().Get("context")); err == nil && n > 0 {
+			options.Before, options.After = n, n
+		}
+		if n, err := strconv.Atoi(r.URL.Query().Get("before")); err == nil && n > 0 {
+			options.Before = n
+		}
+		if n, err := strconv.Atoi(r.URL.Query().Get("after")); err == nil && n > 0 {
+			options.After = n
 		}
 		if options.Query == "" {
 			json.NewEncoder(w).Encode(vfs.SearchResult{Files: []vfs.SearchFileResult{}})
 			return
 		}
 
+		if r.URL.Query().Get("stream") == "true" {
+			streamSearch(w, r, rootPath, options)
+			return
+		}
+
 		result, err := vfs.SearchWorkspace(rootPath, options)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -637,6 +557,16 @@ func handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if options.DryRun {
+		preview, err := vfs.PreviewReplace(rootPath, options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
 	result, err := vfs.ReplaceWorkspace(rootPath, options)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -645,19 +575,56 @@ func handleSearch(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+// searchEvent is one line of a streamed search response
+type searchEvent struct {
+	Type     string                `json:"type"` // "file", "done" or "error"
+	File     *vfs.SearchFileResult `json:"file,omitempty"`
+	Matches  int                   `json:"matches,omitempty"`
+	LimitHit bool                  `json:"limitHit,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+// streamSearch writes search results as newline-delimited JSON, one line per
+// file as it is found, followed by a "done" line with the totals. The search
+// stops as soon as the client disconnects or aborts the request.
+func streamSearch(w http.ResponseWriter, r *http.Request, rootPath string, options vfs.SearchOptions) {
+	w.Header().Set("content-type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+
+	result, err := vfs.StreamSearch(r.Context(), rootPath, options, func(file vfs.SearchFileResult) error {
+		started = true
+		if err := encoder.Encode(searchEvent{Type: "file", File: &file}); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil && !started {
+		// Nothing sent yet, so report bad queries with a proper status
+		w.Header().Set("content-type", "text/plain")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		encoder.Encode(searchEvent{Type: "error", Error: err.Error()})
+		return
+	}
+	encoder.Encode(searchEvent{Type: "done", Matches: result.Matches, LimitHit: result.LimitHit})
+}
+
 func handleDelete(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 
 	// Get root path from query parameter
-	rootPath := r.URL.Query().Get("root")
-	if rootPath == "" || rootPath == "." {
-		// Get current working directory
-		if cwd, err := os.Getwd(); err == nil {
-			rootPath = cwd
-		} else {
-			rootPath = "."
-		}
-	}
+	rootPath := workspaceRoot(r)
 
 	// Handle /files/*
 	if strings.HasPrefix(r.URL.Path, "/files") && len(r.URL.Path) > len("/files") && r.URL.Path[len("/files")] == '/' {
@@ -665,7 +632,7 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
 
 		err := vfs.DeleteFile(path, rootPath)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), writeErrorStatus(err))
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"encoding/json"
+	"lite-ide/internal/vfs"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// indexRefreshInterval is how old the path index of a remote workspace may
+// get before a find request triggers a background rebuild
+const indexRefreshInterval = 30 * time.Second
+
+var (
+	indexRebuildsMu sync.Mutex
+	indexRebuilds   = map[string]bool{}
+)
+
+func handleFind(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	rootPath := workspaceRoot(r)
+
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	idx, built, err := vfs.GetPathIndex(rootPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if built && vfs.IsLocal(rootPath) {
+		watchIndexes(rootPath)
+	} else if !vfs.IsLocal(rootPath) && time.Since(idx.BuiltAt()) > indexRefreshInterval {
+		go rebuildPathIndex(rootPath, idx)
+	}
+
+	json.NewEncoder(w).Encode(idx.Find(r.URL.Query().Get("q"), limit))
+}
+
+// rebuildPathIndex refreshes a remote workspace's index, at most one rebuild
+// per root at a time
+func rebuildPathIndex(root string, idx *vfs.PathIndex) {
+	indexRebuildsMu.Lock()
+	if indexRebuilds[root] {
+		indexRebuildsMu.Unlock()
+		return
+	}
+	indexRebuilds[root] = true
+	indexRebuildsMu.Unlock()
+
+	if err := idx.Rebuild(); err != nil {
+		log.Printf("Failed to rebuild path index for %s: %v", root, err)
+	}
+
+	indexRebuildsMu.Lock()
+	delete(indexRebuilds, root)
+	indexRebuildsMu.Unlock()
+}
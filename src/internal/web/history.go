@@ -0,0 +1,88 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// watchHistorySize is how many events each root keeps for clients that
+	// reconnect with Last-Event-ID
+	watchHistorySize = 1000
+
+	// watchHeartbeat is how often an idle stream gets a comment, so reverse
+	// proxies don't close it
+	watchHeartbeat = 15 * time.Second
+
+	// hubLinger is how long a hub outlives its last client, so a client
+	// reconnecting after a dropped connection can replay what it missed
+	hubLinger = time.Minute
+)
+
+// historyEntry is one event as sent, or a resync that clients got as a
+// snapshot instead of events
+type historyEntry struct {
+	event  fileEvent
+	resync bool
+}
+
+// eventHistory is a ring buffer of the last events a hub broadcast. Event
+// IDs increase by one per event and start from the hub's start time, so IDs
+// from an earlier hub for the same root are never mistaken for current ones.
+type eventHistory struct {
+	entries []historyEntry
+	next    int    // Where the next entry goes once the buffer is full
+	last    uint64 // ID of the newest entry
+}
+
+func newEventHistory() *eventHistory {
+	return &eventHistory{last: uint64(time.Now().UnixMicro())}
+}
+
+// add records an entry and returns its ID.
+func (h *eventHistory) add(entry historyEntry) uint64 {
+	h.last++
+	entry.event.id = h.last
+	if len(h.entries) < watchHistorySize {
+		h.entries = append(h.entries, entry)
+	} else {
+		h.entries[h.next] = entry
+		h.next = (h.next + 1) % watchHistorySize
+	}
+	return h.last
+}
+
+// since returns the events after id. It reports false when some of them
+// are no longer kept, id is unknown, or a resync happened meanwhile; the
+// client needs a snapshot then.
+func (h *eventHistory) since(id uint64) ([]fileEvent, bool) {
+	if id > h.last {
+		return nil, false
+	}
+	missed := int(h.last - id)
+	if missed > len(h.entries) {
+		return nil, false
+	}
+	events := make([]fileEvent, 0, missed)
+	for i := len(h.entries) - missed; i < len(h.entries); i++ {
+		entry := h.entries[(h.next+i)%len(h.entries)]
+		if entry.resync {
+			return nil, false
+		}
+		events = append(events, entry.event)
+	}
+	return events, true
+}
+
+// lastEventID reads the ID of the last event a reconnecting client saw,
+// from the Last-Event-ID header EventSource sends or, for clients that open
+// a new EventSource, the lastEventId parameter.
+func lastEventID(r *http.Request) (uint64, bool) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	return id, err == nil
+}
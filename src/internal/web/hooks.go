@@ -0,0 +1,457 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lite-ide/internal/vfs"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const (
+	// workspaceConfigFile is the per-workspace config at the root
+	workspaceConfigFile = ".nanoide.json"
+
+	// defaultHookDebounce is how long a hook waits for more matching changes
+	// before it runs
+	defaultHookDebounce = 500 * time.Millisecond
+
+	// defaultHookTimeout bounds a hook's command or request
+	defaultHookTimeout = time.Minute
+
+	// maxHookOutput is how much of a command's output is kept per run
+	maxHookOutput = 64 * 1024
+
+	// maxHookRuns is how many runs are kept per workspace
+	maxHookRuns = 50
+
+	// hookWatchDepth caps how many levels below a hook's folder are watched
+	// for it, like the first levels of the root; the hub's deep scan reports
+	// changes further down
+	hookWatchDepth = pollDepth
+)
+
+// hookConfig is one entry of "hooks" in the workspace config. A hook runs
+// a shell command in the workspace or POSTs the changes to a URL.
+type hookConfig struct {
+	Name     string   `json:"name"`
+	Glob     string   `json:"glob"`             // Doublestar pattern; without a slash it matches at any depth
+	Events   []string `json:"events,omitempty"` // Event types to react to, all by default
+	Command  string   `json:"command,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Debounce string   `json:"debounce,omitempty"` // Duration, 500ms by default
+	Timeout  string   `json:"timeout,omitempty"`  // Duration, 1m by default
+}
+
+// hookRun is one execution of a hook, as listed by /api/hooks
+type hookRun struct {
+	ID       int         `json:"id"`
+	Hook     string      `json:"hook"`
+	Events   []fileEvent `json:"events"`
+	Started  time.Time   `json:"started"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Status   string      `json:"status"` // "running", "ok" or "failed"
+	ExitCode *int        `json:"exitCode,omitempty"`
+	HTTPCode int         `json:"httpStatus,omitempty"`
+	Output   string      `json:"output,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// hook is a configured hook with the changes waiting for its debounce
+type hook struct {
+	hookConfig
+	pattern  string
+	debounce time.Duration
+	timeout  time.Duration
+
+	pending []fileEvent
+	timer   *time.Timer
+	running bool
+	rerun   bool // More changes arrived while running
+}
+
+// hookRunner runs the hooks of one workspace from its hub's events
+type hookRunner struct {
+	hub      *watchHub
+	reloadMu sync.Mutex    // Serializes reloads
+	loaded   chan struct{} // Closed once the config was first read
+
+	mu      sync.Mutex
+	hooks   []*hook
+	holding bool         // Whether the runner holds a reference on the hub
+	scopes  []watchScope // Held on the hub so hooked paths are watched
+	deep    bool         // Holding deep changes on the hub for globs reaching below the scopes
+	err     string       // Why the config couldn't be loaded
+	runs    []*hookRun
+	nextID  int
+	closed  bool
+}
+
+// errHooksUntrusted is reported for a workspace whose config has hooks that
+// NANO_IDE_HOOKS doesn't allow to run
+var errHooksUntrusted = errors.New("hooks are disabled for this workspace; add it to NANO_IDE_HOOKS to run them")
+
+// loadHooks reads the hooks from the workspace config. A missing file means
+// no hooks; hooks of a workspace that isn't trusted are refused.
+func loadHooks(root string) ([]*hook, error) {
+	data, err := vfs.ReadFile("/"+workspaceConfigFile, root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var config struct {
+		Hooks []hookConfig `json:"hooks"`
+	}
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", workspaceConfigFile, err)
+	}
+	if len(config.Hooks) > 0 && !vfs.Trusted(root) {
+		return nil, errHooksUntrusted
+	}
+
+	var hooks []*hook
+	for i, entry := range config.Hooks {
+		if entry.Name == "" {
+			entry.Name = fmt.Sprintf("hook %d", i+1)
+		}
+		h := &hook{hookConfig: entry, debounce: defaultHookDebounce, timeout: defaultHookTimeout}
+		if (entry.Command == "") == (entry.URL == "") {
+			return nil, fmt.Errorf("%s: %s needs either a command or a url", workspaceConfigFile, entry.Name)
+		}
+		h.pattern = strings.Trim(entry.Glob, "/")
+		if h.pattern == "" {
+			h.pattern = "**"
+		} else if !strings.Contains(h.pattern, "/") {
+			h.pattern = "**/" + h.pattern
+		}
+		if !doublestar.ValidatePattern(h.pattern) {
+			return nil, fmt.Errorf("%s: %s: invalid glob %q", workspaceConfigFile, entry.Name, entry.Glob)
+		}
+		if entry.Debounce != "" {
+			if h.debounce, err = time.ParseDuration(entry.Debounce); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", workspaceConfigFile, entry.Name, err)
+			}
+		}
+		if entry.Timeout != "" {
+			if h.timeout, err = time.ParseDuration(entry.Timeout); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", workspaceConfigFile, entry.Name, err)
+			}
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// hookScope is the part of the tree a glob can match that is watched for
+// it: its folder before the first wildcard, as deep as the glob reaches but
+// at most hookWatchDepth levels. ok is false when the root's own watches
+// already cover the scope; deep reports that the glob reaches further, so
+// the hub's deep scan has to report the changes below the scope.
+func hookScope(pattern string) (scope watchScope, ok, deep bool) {
+	segments := strings.Split(pattern, "/")
+	literal := 0
+	for literal < len(segments)-1 && !strings.ContainsAny(segments[literal], "*?[{\\") {
+		literal++
+	}
+	depth := len(segments) - literal - 1
+	if strings.Contains(pattern, "**") || depth > hookWatchDepth {
+		depth = hookWatchDepth
+		deep = true
+	}
+	scope = watchScope{strings.Join(segments[:literal], "/"), depth}
+	return scope, scope.folder != "" || scope.depth > pollDepth, deep
+}
+
+// reload replaces the hooks with the workspace config's. The hub stays
+// running while hooks are configured.
+func (r *hookRunner) reload() {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	hooks, err := loadHooks(r.hub.root)
+	if err != nil {
+		log.Printf("Failed to load hooks for %s: %v", r.hub.root, err)
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	for _, old := range r.hooks {
+		if old.timer != nil {
+			old.timer.Stop()
+		}
+	}
+	r.hooks = hooks
+	r.err = ""
+	if err != nil {
+		r.err = err.Error()
+	}
+	oldScopes, oldDeep := r.scopes, r.deep
+	r.scopes = nil
+	r.deep = false
+	for _, h := range hooks {
+		scope, ok, deep := hookScope(h.pattern)
+		if ok {
+			r.scopes = append(r.scopes, scope)
+		}
+		r.deep = r.deep || deep
+	}
+	r.mu.Unlock()
+
+	r.hub.mu.Lock()
+	for _, scope := range r.scopes {
+		r.hub.holdScope(scope)
+	}
+	for _, scope := range oldScopes {
+		r.hub.releaseScope(scope)
+	}
+	if r.deep && !oldDeep {
+		r.hub.holdDeep()
+	} else if !r.deep && oldDeep {
+		r.hub.releaseDeep()
+	}
+	r.hub.mu.Unlock()
+
+	// holding only changes here, under reloadMu, so the hub reference is
+	// taken and dropped without r.mu: stop closes the runner while holding
+	// watchHubsMu, which retain and release take
+	r.mu.Lock()
+	holding := r.holding
+	r.mu.Unlock()
+	switch {
+	case len(hooks) > 0 && !holding:
+		holding = r.hub.retain()
+	case len(hooks) == 0 && holding:
+		holding = false
+		r.hub.release()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.holding = holding
+	select {
+	case <-r.loaded:
+	default:
+		close(r.loaded)
+	}
+}
+
+// handle queues the events each hook is interested in and restarts its
+// debounce.
+func (r *hookRunner) handle(events []fileEvent) {
+	for _, event := range events {
+		if event.Path == "/"+workspaceConfigFile {
+			go r.reload()
+			break
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, h := range r.hooks {
+		matched := false
+		for _, event := range events {
+			if h.matches(event) {
+				h.pending = append(h.pending, event)
+				matched = true
+			}
+		}
+		if !matched {
+			continue
+		}
+		if h.timer != nil {
+			h.timer.Stop()
+		}
+		h.timer = time.AfterFunc(h.debounce, func() { r.fire(h) })
+	}
+}
+
+func (h *hook) matches(event fileEvent) bool {
+	if len(h.Events) > 0 {
+		found := false
+		for _, kind := range h.Events {
+			found = found || kind == event.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	return doublestar.MatchUnvalidated(h.pattern, strings.TrimPrefix(event.Path, "/"))
+}
+
+// fire runs a hook with its pending events once its debounce elapsed.
+// Runs of one hook never overlap; changes arriving meanwhile run it again.
+func (r *hookRunner) fire(h *hook) {
+	r.mu.Lock()
+	if r.closed || len(h.pending) == 0 {
+		r.mu.Unlock()
+		return
+	}
+	if h.running {
+		h.rerun = true
+		r.mu.Unlock()
+		return
+	}
+	h.running = true
+	events := h.pending
+	h.pending = nil
+	r.nextID++
+	run := &hookRun{ID: r.nextID, Hook: h.Name, Events: events, Started: time.Now(), Status: "running"}
+	r.runs = append(r.runs, run)
+	if len(r.runs) > maxHookRuns {
+		r.runs = r.runs[len(r.runs)-maxHookRuns:]
+	}
+	r.mu.Unlock()
+
+	output, exitCode, httpCode, err := r.execute(h, events)
+
+	r.mu.Lock()
+	finished := time.Now()
+	run.Finished = &finished
+	run.Output = output
+	run.ExitCode = exitCode
+	run.HTTPCode = httpCode
+	run.Status = "ok"
+	if err != nil {
+		run.Status = "failed"
+		run.Error = err.Error()
+		log.Printf("Hook %q failed for %s: %v", h.Name, r.hub.root, err)
+	}
+	h.running = false
+	rerun := h.rerun
+	h.rerun = false
+	r.mu.Unlock()
+
+	if rerun {
+		r.fire(h)
+	}
+}
+
+// execute runs the hook's command or request for events.
+func (r *hookRunner) execute(h *hook, events []fileEvent) (string, *int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	if h.URL != "" {
+		body, _ := json.Marshal(map[string]any{"hook": h.Name, "root": r.hub.root, "events": events})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+		if err != nil {
+			return "", nil, 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		defer resp.Body.Close()
+		var output bytes.Buffer
+		output.ReadFrom(io.LimitReader(resp.Body, maxHookOutput))
+		if resp.StatusCode >= 300 {
+			return output.String(), nil, resp.StatusCode, fmt.Errorf("%s returned %s", h.URL, resp.Status)
+		}
+		return output.String(), nil, resp.StatusCode, nil
+	}
+
+	if !vfs.IsLocal(r.hub.root) {
+		return "", nil, 0, errors.New("command hooks need a local workspace")
+	}
+	paths := make([]string, len(events))
+	for i, event := range events {
+		paths[i] = strings.TrimPrefix(event.Path, "/")
+	}
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, flag, h.Command)
+	cmd.Dir = r.hub.root
+	cmd.Env = append(os.Environ(),
+		"NANO_IDE_ROOT="+r.hub.root,
+		"NANO_IDE_HOOK="+h.Name,
+		"NANO_IDE_EVENT="+events[len(events)-1].Type,
+		"NANO_IDE_PATH="+paths[len(paths)-1],
+		"NANO_IDE_PATHS="+strings.Join(paths, "\n"),
+	)
+	output := &cappedBuffer{limit: maxHookOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	if cmd.ProcessState == nil {
+		return output.String(), nil, 0, err
+	}
+	exitCode := cmd.ProcessState.ExitCode()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s", h.timeout)
+	}
+	return output.String(), &exitCode, 0, err
+}
+
+// close stops pending runs when the hub stops.
+func (r *hookRunner) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, h := range r.hooks {
+		if h.timer != nil {
+			h.timer.Stop()
+		}
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written to it
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// handleHooks lists a workspace's hooks and their recent runs, newest
+// first. Asking starts watching the workspace, so hooks also run without
+// an open explorer.
+func handleHooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	rootPath := workspaceRoot(r)
+
+	hub, err := acquireWatchHub(rootPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer hub.release()
+
+	runner := hub.hooks
+	<-runner.loaded
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	hooks := make([]hookConfig, len(runner.hooks))
+	for i, h := range runner.hooks {
+		hooks[i] = h.hookConfig
+	}
+	runs := make([]*hookRun, 0, len(runner.runs))
+	for i := len(runner.runs) - 1; i >= 0; i-- {
+		runs = append(runs, runner.runs[i])
+	}
+	json.NewEncoder(w).Encode(map[string]any{"hooks": hooks, "error": runner.err, "runs": runs})
+}
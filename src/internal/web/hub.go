@@ -0,0 +1,568 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"lite-ide/internal/vfs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// errUnknownWatchClient is returned for expand requests from a client that
+// isn't connected to the root's watch stream
+var errUnknownWatchClient = errors.New("unknown watch client")
+
+// maxExpandDepth caps how many levels below an expanded folder are watched
+const maxExpandDepth = 4
+
+// clientQueueSize is how many batches may wait for a slow client before it
+// is switched to a snapshot
+const clientQueueSize = 64
+
+// watchUpdate is one batch as delivered to every client of a hub
+type watchUpdate struct {
+	events   []fileEvent
+	resync   bool   // Send a snapshot instead of the events
+	id       uint64 // ID of the last event, which a snapshot stands for
+	contents []contentChange
+}
+
+// watchClient is one /api/watch connection. Only the connection's handler
+// writes to the response; the hub hands it updates over the channel.
+type watchClient struct {
+	id      string
+	updates chan watchUpdate
+	resync  chan struct{}                // Signalled when updates overflowed
+	folders map[string]int               // Expanded folders and their depth, guarded by the hub's mu
+	files   map[string]*fileSubscription // Subscribed files, guarded by the hub's mu
+}
+
+// send queues an update without blocking the hub. A client that can't keep
+// up loses the queued updates and is sent a snapshot instead.
+func (c *watchClient) send(update watchUpdate) {
+	select {
+	case c.updates <- update:
+	default:
+		select {
+		case c.resync <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watchHub watches one workspace root on behalf of all its /api/watch
+// clients. Local roots share one fsnotify watcher; remote roots, and local
+// ones fsnotify can't cover, share one poller. The hub is reference counted
+// and stops hubLinger after its last client left.
+type watchHub struct {
+	root      string
+	refs      int           // Guarded by watchHubsMu
+	stopTimer *time.Timer   // Guarded by watchHubsMu
+	ready     chan struct{} // Closed once start returned
+	startErr  error         // Why start failed, set before ready is closed
+	done      chan struct{}
+
+	// Natively watched roots; watcher is guarded by mu
+	watcher *fsnotify.Watcher
+	ignore  *vfs.Ignorer
+
+	// Polled roots: stamps of the root and of each held scope
+	stamps map[watchScope]map[string]vfs.Stamp
+
+	// Signalled when the native watcher ran out of watches
+	fallback chan struct{}
+
+	mu       sync.Mutex
+	polling  bool
+	clients  map[string]*watchClient
+	dirs     map[string]int          // Watched directories and how many reasons each has to stay watched
+	held     map[watchScope][]string // Scopes clients asked for, with the directories they watch natively
+	interest map[watchScope]int      // Clients holding each scope
+	history  *eventHistory
+	hooks    *hookRunner
+	indexes  *indexUpdater // Set while the workspace has indexes
+	deep     int           // Interests in changes at any depth, see holdDeep
+	deepStop chan struct{} // Stops the deep scan once deep drops to zero
+}
+
+// watchScope is a folder watched on behalf of clients, together with how
+// many levels below it are watched too
+type watchScope struct {
+	folder string
+	depth  int
+}
+
+var (
+	watchHubsMu     sync.Mutex
+	watchHubs       = map[string]*watchHub{}
+	watchClientHubs = map[string]*watchHub{} // By client ID, for expand and collapse
+)
+
+// acquireWatchHub returns the running hub for root, starting one if needed.
+// Every successful call must be paired with release.
+//
+// Starting walks or scans the first levels of the root, so it runs without
+// watchHubsMu; callers for the same root wait until the hub is ready.
+func acquireWatchHub(root string) (*watchHub, error) {
+	watchHubsMu.Lock()
+	if hub, ok := watchHubs[root]; ok {
+		hub.refs++
+		if hub.stopTimer != nil {
+			hub.stopTimer.Stop()
+			hub.stopTimer = nil
+		}
+		watchHubsMu.Unlock()
+		<-hub.ready
+		if hub.startErr != nil {
+			return nil, hub.startErr
+		}
+		return hub, nil
+	}
+
+	hub := &watchHub{
+		root:     root,
+		refs:     1,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+		clients:  make(map[string]*watchClient),
+		dirs:     make(map[string]int),
+		fallback: make(chan struct{}, 1),
+		held:     make(map[watchScope][]string),
+		interest: make(map[watchScope]int),
+		history:  newEventHistory(),
+	}
+	// Before start, whose loops broadcast to the hooks
+	hub.hooks = &hookRunner{hub: hub, loaded: make(chan struct{})}
+	watchHubs[root] = hub
+	watchHubsMu.Unlock()
+
+	if err := hub.start(); err != nil {
+		watchHubsMu.Lock()
+		delete(watchHubs, root)
+		watchHubsMu.Unlock()
+		hub.startErr = err
+		close(hub.ready)
+		return nil, err
+	}
+	close(hub.ready)
+	go hub.hooks.reload()
+	return hub, nil
+}
+
+// retain adds a reference for the hub's own use, reporting false if the hub
+// already stopped.
+func (h *watchHub) retain() bool {
+	watchHubsMu.Lock()
+	defer watchHubsMu.Unlock()
+	if watchHubs[h.root] != h {
+		return false
+	}
+	h.refs++
+	if h.stopTimer != nil {
+		h.stopTimer.Stop()
+		h.stopTimer = nil
+	}
+	return true
+}
+
+// release drops one reference. When it was the last, the hub keeps its
+// history for hubLinger before it stops.
+func (h *watchHub) release() {
+	watchHubsMu.Lock()
+	defer watchHubsMu.Unlock()
+	h.refs--
+	if h.refs == 0 {
+		h.stopTimer = time.AfterFunc(hubLinger, h.stop)
+	}
+}
+
+// stop shuts the hub down unless a client came back meanwhile.
+func (h *watchHub) stop() {
+	watchHubsMu.Lock()
+	if h.refs > 0 || watchHubs[h.root] != h {
+		watchHubsMu.Unlock()
+		return
+	}
+	delete(watchHubs, h.root)
+	close(h.done)
+	watchHubsMu.Unlock()
+
+	// The runner's lock is never taken while holding watchHubsMu
+	h.hooks.close()
+	h.mu.Lock()
+	if h.watcher != nil {
+		h.watcher.Close()
+		h.watcher = nil
+	}
+	h.mu.Unlock()
+}
+
+// start sets up the watcher or the initial poll scan and runs the hub loop.
+func (h *watchHub) start() error {
+	// Remote workspaces can't be watched with fsnotify, poll them instead
+	if !vfs.IsLocal(h.root) || forcePolling(h.root) {
+		return h.startPoll()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Native file watching unavailable for %s, polling instead: %v", h.root, err)
+		return h.startPoll()
+	}
+	ignore, err := vfs.NewIgnorer(h.root)
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// Add root directory and first 2 levels of subdirectories to watcher
+	err = addLazyWatch(watcher, h.root, ignore, 2)
+	if errors.Is(err, errWatchBudget) || (err == nil && len(watcher.WatchList()) == 0) {
+		watcher.Close()
+		log.Printf("Native file watching unavailable for %s, polling instead: %v", h.root, err)
+		return h.startPoll()
+	}
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	// The initial directories stay watched for the hub's lifetime
+	for _, dir := range watcher.WatchList() {
+		h.dirs[dir] = 1
+	}
+	h.watcher = watcher
+	h.ignore = ignore
+	go h.runNotify()
+	return nil
+}
+
+// subscribe adds a client. A client resuming after lastID gets the events
+// it missed; otherwise, or when they can't be replayed, it needs a
+// snapshot, which the returned ID stands for.
+func (h *watchHub) subscribe(lastID uint64, resume bool) (*watchClient, []fileEvent, bool, uint64) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	client := &watchClient{
+		id:      hex.EncodeToString(id),
+		updates: make(chan watchUpdate, clientQueueSize),
+		resync:  make(chan struct{}, 1),
+		folders: make(map[string]int),
+	}
+	h.mu.Lock()
+	h.clients[client.id] = client
+	var missed []fileEvent
+	replayed := false
+	if resume {
+		missed, replayed = h.history.since(lastID)
+	}
+	snapshotID := h.history.last
+	h.mu.Unlock()
+	watchHubsMu.Lock()
+	watchClientHubs[client.id] = h
+	watchHubsMu.Unlock()
+	return client, missed, replayed, snapshotID
+}
+
+// lastEventID returns the ID of the newest event broadcast.
+func (h *watchHub) lastEventID() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.history.last
+}
+
+// mode names how the hub sees changes: "native" or "poll"
+func (h *watchHub) mode() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.polling {
+		return "poll"
+	}
+	return "native"
+}
+
+// unsubscribe removes a client and releases the folders it had expanded
+// and the files it had subscribed to.
+func (h *watchHub) unsubscribe(client *watchClient) {
+	watchHubsMu.Lock()
+	delete(watchClientHubs, client.id)
+	watchHubsMu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client.id)
+	for folder, depth := range client.folders {
+		h.releaseScope(watchScope{folder, depth})
+	}
+	for name := range client.files {
+		h.releaseScope(watchScope{watchFolderName(path.Dir(name)), 0})
+	}
+}
+
+// expandFolder registers a client's interest in a folder shown expanded in
+// its explorer. The folder and its subfolders down to depth levels are
+// watched until no client has it expanded.
+func (h *watchHub) expandFolder(clientID, folder string, depth int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client, ok := h.clients[clientID]
+	if !ok {
+		return errUnknownWatchClient
+	}
+	if previous, ok := client.folders[folder]; ok {
+		if previous == depth {
+			return nil
+		}
+		h.releaseScope(watchScope{folder, previous})
+	}
+	client.folders[folder] = depth
+	h.holdScope(watchScope{folder, depth})
+	return nil
+}
+
+// holdScope adds one interest in a scope, watching its directories when it
+// is new. Called with mu held.
+func (h *watchHub) holdScope(scope watchScope) {
+	h.interest[scope]++
+	if h.interest[scope] > 1 {
+		return
+	}
+	h.held[scope] = nil
+	if h.watcher == nil {
+		// The poll loop scans held scopes on its next tick
+		return
+	}
+
+	var walk func(dir string, level int)
+	walk = func(dir string, level int) {
+		rel, err := filepath.Rel(h.root, dir)
+		if err != nil || (rel != "." && h.ignore.Ignored(filepath.ToSlash(rel), true)) {
+			return
+		}
+		if _, err := os.Stat(dir); err != nil {
+			// Created later, it is watched from its parent's events
+			return
+		}
+		if h.dirs[dir] == 0 && !h.addWatch(dir) {
+			return
+		}
+		h.dirs[dir]++
+		h.held[scope] = append(h.held[scope], dir)
+		if level >= scope.depth {
+			return
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				walk(filepath.Join(dir, entry.Name()), level+1)
+			}
+		}
+	}
+	walk(filepath.Join(h.root, filepath.FromSlash(scope.folder)), 0)
+}
+
+// addWatch adds a native watch for dir. Running out of the watch budget
+// switches the hub to polling. Called with mu held.
+func (h *watchHub) addWatch(dir string) bool {
+	err := errWatchBudget
+	if len(h.dirs) < maxWatches {
+		err = h.watcher.Add(dir)
+	}
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, errWatchBudget) || errors.Is(err, syscall.ENOSPC) {
+		select {
+		case h.fallback <- struct{}{}:
+		default:
+		}
+		return false
+	}
+	log.Printf("Warning: failed to add watch for %s: %v", dir, err)
+	return false
+}
+
+// collapseFolder drops a client's interest in a folder.
+func (h *watchHub) collapseFolder(clientID, folder string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client, ok := h.clients[clientID]
+	if !ok {
+		return errUnknownWatchClient
+	}
+	if depth, ok := client.folders[folder]; ok {
+		delete(client.folders, folder)
+		h.releaseScope(watchScope{folder, depth})
+	}
+	return nil
+}
+
+// releaseScope drops one interest in a scope, unwatching its directories
+// once nobody needs them. Called with mu held.
+func (h *watchHub) releaseScope(scope watchScope) {
+	h.interest[scope]--
+	if h.interest[scope] > 0 {
+		return
+	}
+	delete(h.interest, scope)
+	for _, dir := range h.held[scope] {
+		if h.dirs[dir]--; h.dirs[dir] <= 0 {
+			delete(h.dirs, dir)
+			h.watcher.Remove(dir)
+		}
+	}
+	delete(h.held, scope)
+}
+
+// watchFolderName normalizes a folder path from the explorer to a
+// root-relative name that can't leave the root.
+func watchFolderName(folder string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(folder)), "/")
+}
+
+// watchHubForClient returns the hub a watch client is subscribed to.
+func watchHubForClient(clientID string) (*watchHub, bool) {
+	watchHubsMu.Lock()
+	defer watchHubsMu.Unlock()
+	hub, ok := watchClientHubs[clientID]
+	return hub, ok
+}
+
+// broadcast hands a batch to every client.
+func (h *watchHub) broadcast(batch *changeBatch) {
+	events := batch.events()
+	update := watchUpdate{events: events, resync: batch.resync || len(events) > maxBatchEvents}
+	if update.resync {
+		update.events = nil
+	}
+	files := h.readSubscribed(update)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if update.resync {
+		update.id = h.history.add(historyEntry{resync: true})
+	}
+	for i := range update.events {
+		update.id = h.history.add(historyEntry{event: update.events[i]})
+		update.events[i].id = update.id
+	}
+	for _, client := range h.clients {
+		clientUpdate := update
+		clientUpdate.contents = client.contentChanges(files)
+		client.send(clientUpdate)
+	}
+	h.hooks.handle(update.events)
+	if h.indexes != nil {
+		h.indexes.queue(update)
+	}
+}
+
+// runNotify turns fsnotify events into batches. Changes are collected for
+// watchBatchDelay after the first one, then broadcast together.
+func (h *watchHub) runNotify() {
+	watcher := h.watcher
+	var batch changeBatch
+	var flush <-chan time.Time
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-flush:
+			h.broadcast(&batch)
+			batch = changeBatch{}
+			flush = nil
+		case <-h.fallback:
+			if !batch.empty() {
+				h.broadcast(&batch)
+			}
+			h.switchToPoll(watcher)
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if h.addEvent(&batch, event) && flush == nil {
+				flush = time.After(watchBatchDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+			// Dropped events leave clients out of date
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				batch.resync = true
+				if flush == nil {
+					flush = time.After(watchBatchDelay)
+				}
+			}
+		}
+	}
+}
+
+// addEvent records one fsnotify event in batch, reporting whether it was
+// kept.
+func (h *watchHub) addEvent(batch *changeBatch, event fsnotify.Event) bool {
+	// Skip temporary files and ignored paths
+	if strings.HasSuffix(event.Name, "~") {
+		return false
+	}
+	relPath, err := filepath.Rel(h.root, event.Name)
+	if err != nil || relPath == "." {
+		return false
+	}
+	relPath = filepath.ToSlash(relPath)
+	base := filepath.Base(event.Name)
+	if base == ".gitignore" || base == ".nanoideignore" {
+		// Ignore rules changed, so the visible tree may change anywhere
+		h.ignore.Reset()
+		batch.resync = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	info, statErr := os.Stat(event.Name)
+	isDir := (statErr == nil && info.IsDir()) || (statErr != nil && h.dirs[event.Name] > 0)
+	if h.ignore.Ignored(relPath, isDir) {
+		return false
+	}
+
+	nodeType := "file"
+	if isDir {
+		nodeType = "folder"
+	}
+	path := "/" + relPath
+	switch {
+	case event.Op&fsnotify.Create != 0:
+		batch.add("created", path, nodeType)
+		// If new directory created, add it to watch
+		if isDir && h.dirs[event.Name] == 0 && h.addWatch(event.Name) {
+			h.dirs[event.Name] = 1
+		}
+	case event.Op&fsnotify.Remove != 0:
+		batch.add("deleted", path, nodeType)
+		delete(h.dirs, event.Name)
+	case event.Op&fsnotify.Rename != 0:
+		batch.rename(path, nodeType)
+		delete(h.dirs, event.Name)
+	case event.Op&fsnotify.Write != 0:
+		if isDir {
+			return false
+		}
+		batch.add("modified", path, nodeType)
+	default:
+		return false
+	}
+	return true
+}
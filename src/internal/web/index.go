@@ -0,0 +1,137 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"lite-ide/internal/vfs"
+	"log"
+	"net/http"
+	"sync"
+)
+
+var (
+	indexUpdatersMu sync.Mutex
+	indexUpdaters   = map[string]*indexUpdater{}
+)
+
+// indexUpdater keeps a workspace's path index and search index current from
+// its watch hub's batches. It holds a reference on the hub for as long as the
+// indexes exist, which is as long as the server runs.
+type indexUpdater struct {
+	hub  *watchHub
+	wake chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]struct{} // Changed paths not yet applied
+	rebuild bool                // The hub resynced, so changes are unknown
+}
+
+// handleIndex reports (GET) or rebuilds (POST) the workspace search index
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	rootPath := workspaceRoot(r)
+
+	var status vfs.IndexStatus
+	var err error
+	if r.Method == "POST" {
+		status, err = vfs.RebuildSearchIndex(rootPath)
+	} else {
+		status, err = vfs.GetIndexStatus(rootPath)
+	}
+	if errors.Is(err, vfs.ErrIndexUnsupported) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status.State != vfs.IndexNone {
+		watchIndexes(rootPath)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// watchIndexes starts, once per local workspace, keeping its path index
+// and search index current with the workspace's watch hub. The hub also
+// reports changes below the levels it watches: quick open must find files
+// anywhere, and indexed searches only scan the files the index picks.
+func watchIndexes(root string) {
+	indexUpdatersMu.Lock()
+	defer indexUpdatersMu.Unlock()
+	if indexUpdaters[root] != nil || !vfs.IsLocal(root) {
+		return
+	}
+	hub, err := acquireWatchHub(root)
+	if err != nil {
+		log.Printf("Failed to watch indexes for %s: %v", root, err)
+		return
+	}
+	updater := &indexUpdater{hub: hub, wake: make(chan struct{}, 1), pending: make(map[string]struct{})}
+	indexUpdaters[root] = updater
+	hub.mu.Lock()
+	hub.indexes = updater
+	hub.holdDeep()
+	hub.mu.Unlock()
+	go updater.run()
+}
+
+// queue records a batch for the indexes without blocking the hub. Batches
+// arriving while the indexes are updated are coalesced.
+func (u *indexUpdater) queue(update watchUpdate) {
+	u.mu.Lock()
+	if update.resync {
+		u.rebuild = true
+	}
+	for _, event := range update.events {
+		u.pending[event.Path] = struct{}{}
+		if event.OldPath != "" {
+			u.pending[event.OldPath] = struct{}{}
+		}
+	}
+	u.mu.Unlock()
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run applies queued changes to the indexes, a batch at a time.
+func (u *indexUpdater) run() {
+	root := u.hub.root
+	for {
+		select {
+		case <-u.hub.done:
+			return
+		case <-u.wake:
+		}
+
+		u.mu.Lock()
+		rebuild := u.rebuild
+		names := make([]string, 0, len(u.pending))
+		for name := range u.pending {
+			names = append(names, name)
+		}
+		u.pending = make(map[string]struct{})
+		u.rebuild = false
+		u.mu.Unlock()
+
+		if rebuild {
+			if err := vfs.RebuildPathIndex(root); err != nil {
+				log.Printf("Failed to rebuild path index for %s: %v", root, err)
+			}
+			if status, err := vfs.GetIndexStatus(root); err == nil && status.State != vfs.IndexNone {
+				vfs.RebuildSearchIndex(root)
+			}
+			continue
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if err := vfs.UpdatePathIndex(root, names); err != nil {
+			log.Printf("Failed to update path index for %s: %v", root, err)
+		}
+		vfs.UpdateSearchIndex(root, names)
+	}
+}
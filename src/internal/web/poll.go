@@ -0,0 +1,302 @@
+package web
+
+import (
+	"lite-ide/internal/vfs"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultPollInterval is how often polled workspaces are rescanned
+// unless NANO_IDE_POLL_INTERVAL says otherwise
+const defaultPollInterval = 2 * time.Second
+
+// pollDepth is how many levels below the root are scanned, matching the
+// directories the native watcher starts with
+const pollDepth = 2
+
+// defaultDeepScanInterval is how often the whole tree is rescanned for
+// changes below the watched levels, unless NANO_IDE_DEEP_SCAN_INTERVAL says
+// otherwise
+const defaultDeepScanInterval = 5 * time.Second
+
+func pollInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("NANO_IDE_POLL_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return defaultPollInterval
+}
+
+func deepScanInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("NANO_IDE_DEEP_SCAN_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return defaultDeepScanInterval
+}
+
+// forcePolling reports whether NANO_IDE_POLL selects polling for a local
+// root: "true" for every workspace, or a comma-separated list of workspace
+// paths (network mounts, FUSE and container volumes where fsnotify stays
+// silent).
+func forcePolling(root string) bool {
+	value := os.Getenv("NANO_IDE_POLL")
+	if value == "1" || value == "true" {
+		return true
+	}
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil && abs == rootAbs {
+			return true
+		}
+	}
+	return false
+}
+
+// startPoll takes the first scan and starts polling the root
+func (h *watchHub) startPoll() error {
+	stamps, err := h.scan()
+	if err != nil {
+		return err
+	}
+	h.stamps = stamps
+	h.polling = true
+	go h.runPoll()
+	return nil
+}
+
+// switchToPoll replaces a native watcher that ran out of watches with
+// polling. Clients get a snapshot since changes may be missed meanwhile.
+func (h *watchHub) switchToPoll(watcher *fsnotify.Watcher) {
+	h.mu.Lock()
+	if h.watcher == nil {
+		// Released while switching
+		h.mu.Unlock()
+		return
+	}
+	h.watcher = nil
+	h.polling = true
+	h.dirs = make(map[string]int)
+	for scope := range h.held {
+		h.held[scope] = nil
+	}
+	h.mu.Unlock()
+	watcher.Close()
+	log.Printf("Watch budget exhausted for %s, polling instead", h.root)
+
+	stamps, err := h.scan()
+	if err != nil {
+		log.Printf("Poll scan failed for %s: %v", h.root, err)
+		stamps = make(map[watchScope]map[string]vfs.Stamp)
+	}
+	h.stamps = stamps
+	h.broadcast(&changeBatch{resync: true})
+	h.runPoll()
+}
+
+// scan stamps the first levels of the root and every held scope
+func (h *watchHub) scan() (map[watchScope]map[string]vfs.Stamp, error) {
+	h.mu.Lock()
+	held := make([]watchScope, 0, len(h.held))
+	for scope := range h.held {
+		held = append(held, scope)
+	}
+	h.mu.Unlock()
+
+	root := watchScope{"", pollDepth}
+	stamps, err := vfs.Scan(h.root, root.folder, root.depth)
+	if err != nil {
+		return nil, err
+	}
+	scopes := map[watchScope]map[string]vfs.Stamp{root: stamps}
+	for _, scope := range held {
+		if stamps, err := vfs.Scan(h.root, scope.folder, scope.depth); err == nil {
+			scopes[scope] = stamps
+		}
+	}
+	return scopes, nil
+}
+
+// runPoll detects changes in workspaces fsnotify can't see by rescanning
+// them and comparing stamps
+func (h *watchHub) runPoll() {
+	ticker := time.NewTicker(pollInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			current, err := h.scan()
+			if err != nil {
+				log.Printf("Poll scan failed for %s: %v", h.root, err)
+				continue
+			}
+			// Scopes held since the last scan only start their baseline
+			scopes := make([]watchScope, 0, len(current))
+			for scope := range current {
+				scopes = append(scopes, scope)
+			}
+			sort.Slice(scopes, func(i, j int) bool {
+				if scopes[i].folder != scopes[j].folder {
+					return scopes[i].folder < scopes[j].folder
+				}
+				return scopes[i].depth < scopes[j].depth
+			})
+			batch := &changeBatch{}
+			for _, scope := range scopes {
+				if previous, ok := h.stamps[scope]; ok {
+					diffStamps(batch, previous, current[scope])
+				}
+			}
+			h.stamps = current
+			if !batch.empty() {
+				h.broadcast(batch)
+			}
+		}
+	}
+}
+
+// holdDeep adds one interest in changes at any depth. The first levels and
+// expanded folders stay watched as before; a periodic scan of the whole
+// tree reports changes below them. Called with mu held.
+func (h *watchHub) holdDeep() {
+	h.deep++
+	if h.deep == 1 {
+		h.deepStop = make(chan struct{})
+		go h.runDeepScan(h.deepStop)
+	}
+}
+
+// releaseDeep drops one interest in changes at any depth. Called with mu
+// held.
+func (h *watchHub) releaseDeep() {
+	h.deep--
+	if h.deep == 0 {
+		close(h.deepStop)
+		h.deepStop = nil
+	}
+}
+
+// runDeepScan rescans the whole tree and reports the changes that the
+// hub's watches or poll scans don't already cover
+func (h *watchHub) runDeepScan(stop chan struct{}) {
+	previous, err := vfs.Scan(h.root, "", math.MaxInt)
+	if err != nil {
+		log.Printf("Deep scan failed for %s: %v", h.root, err)
+		previous = make(map[string]vfs.Stamp)
+	}
+	ticker := time.NewTicker(deepScanInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		current, err := vfs.Scan(h.root, "", math.MaxInt)
+		if err != nil {
+			log.Printf("Deep scan failed for %s: %v", h.root, err)
+			continue
+		}
+		h.mu.Lock()
+		before, after := h.unwatched(previous), h.unwatched(current)
+		h.mu.Unlock()
+		previous = current
+
+		batch := &changeBatch{}
+		diffStamps(batch, before, after)
+		if !batch.empty() {
+			h.broadcast(batch)
+		}
+	}
+}
+
+// unwatched returns the stamps of entries whose changes only the deep scan
+// sees. Called with mu held.
+func (h *watchHub) unwatched(stamps map[string]vfs.Stamp) map[string]vfs.Stamp {
+	result := make(map[string]vfs.Stamp)
+	for name, stamp := range stamps {
+		if !h.watched(name) {
+			result[name] = stamp
+		}
+	}
+	return result
+}
+
+// watched reports whether changes to an entry reach the hub without the
+// deep scan: natively its directory is watched, when polling it lies within
+// the first levels or a held scope. Called with mu held.
+func (h *watchHub) watched(name string) bool {
+	if h.watcher != nil {
+		return h.dirs[filepath.Join(h.root, filepath.FromSlash(path.Dir(name)))] > 0
+	}
+	if strings.Count(name, "/") <= pollDepth {
+		return true
+	}
+	for scope := range h.held {
+		rel := name
+		if scope.folder != "" {
+			if !strings.HasPrefix(name, scope.folder+"/") {
+				continue
+			}
+			rel = name[len(scope.folder)+1:]
+		}
+		if strings.Count(rel, "/") <= scope.depth {
+			return true
+		}
+	}
+	return false
+}
+
+// diffStamps adds the difference between two scans to batch
+func diffStamps(batch *changeBatch, previous, current map[string]vfs.Stamp) {
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		before, existed := previous[name]
+		after, exists := current[name]
+		switch {
+		case !existed:
+			batch.add("created", "/"+name, stampNodeType(after))
+		case !exists:
+			batch.add("deleted", "/"+name, stampNodeType(before))
+		case before.IsDir != after.IsDir:
+			batch.add("modified", "/"+name, stampNodeType(after))
+		case !after.IsDir && (before.Size != after.Size || !before.ModTime.Equal(after.ModTime)):
+			batch.add("modified", "/"+name, "file")
+		}
+	}
+}
+
+func stampNodeType(stamp vfs.Stamp) string {
+	if stamp.IsDir {
+		return "folder"
+	}
+	return "file"
+}
@@ -0,0 +1,121 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lite-ide/internal/terminal"
+	"net/http"
+	"strings"
+)
+
+// handleTerminals manages terminal sessions: GET and POST /terminals list
+// and create them, GET, PATCH and DELETE /terminals/{id} describe, rename
+// and kill one, and POST /terminals/{id}/signal signals it. GET
+// /terminals/profiles lists the profiles a workspace can start.
+func handleTerminals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	if r.URL.Path == "/terminals" {
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(terminal.List())
+		case "POST":
+			var opts terminal.Options
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			session, err := terminal.Create(opts)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(session.Info())
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if r.URL.Path == "/terminals/profiles" {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rootPath := workspaceRoot(r)
+		profiles, err := terminal.Profiles(rootPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(profiles)
+		return
+	}
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/terminals/"), "/")
+	session := terminal.Lookup(id)
+	if session == nil || (action != "" && action != "signal") {
+		http.Error(w, "terminal session not found", http.StatusNotFound)
+		return
+	}
+
+	if action == "signal" {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Signal     string `json:"signal"`
+			Foreground bool   `json:"foreground"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := session.Signal(req.Signal, req.Foreground); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, terminal.ErrUnknownSignal) {
+				status = http.StatusBadRequest
+			} else if errors.Is(err, errors.ErrUnsupported) {
+				status = http.StatusNotImplemented
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		response := map[string]string{
+			"status":  "success",
+			"message": fmt.Sprintf("Sent %s to %s", req.Signal, id),
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(session.Info())
+	case "PATCH":
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		session.Rename(req.Name)
+		json.NewEncoder(w).Encode(session.Info())
+	case "DELETE":
+		if err := session.Kill(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response := map[string]string{
+			"status":  "success",
+			"message": fmt.Sprintf("Killed: %s", id),
+		}
+		json.NewEncoder(w).Encode(response)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,293 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lite-ide/internal/vfs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watchBatchDelay is how long changes are collected before they are
+	// sent as one batch
+	watchBatchDelay = 150 * time.Millisecond
+
+	// maxBatchEvents is the largest batch sent as individual events; bigger
+	// bursts (checkouts, installs) are sent as a snapshot instead
+	maxBatchEvents = 500
+
+	// maxWatches is the watch budget of one root; workspaces that need more
+	// are polled instead
+	maxWatches = 1000
+)
+
+// errWatchBudget is returned when a root needs more watches than allowed
+// or than the system's inotify limit leaves
+var errWatchBudget = errors.New("watch budget exhausted")
+
+// fileEvent is one typed change sent on /api/watch as an SSE event named
+// after its type
+type fileEvent struct {
+	Type     string `json:"type"` // "created", "modified", "deleted" or "renamed"
+	Path     string `json:"path"`
+	OldPath  string `json:"oldPath,omitempty"` // Set for "renamed"
+	NodeType string `json:"nodeType"`          // "file" or "folder"
+
+	renameSource bool   // A deletion caused by a rename, paired with a creation when sent
+	id           uint64 // Sent as the SSE event ID
+}
+
+// changeBatch coalesces the changes seen during one batch window, so each
+// path is reported once with its net effect
+type changeBatch struct {
+	order   []string
+	changes map[string]*fileEvent
+	resync  bool // Send a snapshot instead of the changes
+}
+
+func (b *changeBatch) add(kind, path, nodeType string) {
+	if b.changes == nil {
+		b.changes = make(map[string]*fileEvent)
+	}
+	event, seen := b.changes[path]
+	if !seen {
+		event = &fileEvent{Type: kind, Path: path, NodeType: nodeType}
+		b.changes[path] = event
+		b.order = append(b.order, path)
+		return
+	}
+
+	switch kind {
+	case "created":
+		// Deleted and recreated (editors saving via rename) nets to modified
+		if event.Type == "deleted" {
+			event.Type = "modified"
+			event.NodeType = nodeType
+			event.renameSource = false
+		}
+	case "deleted":
+		if event.Type == "created" {
+			delete(b.changes, path)
+			return
+		}
+		event.Type = "deleted"
+		event.renameSource = false
+	}
+}
+
+// rename records the old side of a rename; the new side arrives as a
+// creation and the two are paired in events.
+func (b *changeBatch) rename(path, nodeType string) {
+	b.add("deleted", path, nodeType)
+	if event, ok := b.changes[path]; ok && event.Type == "deleted" {
+		event.renameSource = true
+	}
+}
+
+func (b *changeBatch) empty() bool {
+	return len(b.changes) == 0 && !b.resync
+}
+
+// events returns the batch in arrival order, pairing each rename source
+// with the next creation of the same node type.
+func (b *changeBatch) events() []fileEvent {
+	var events []fileEvent
+	emitted := make(map[string]bool, len(b.changes))
+	for _, path := range b.order {
+		if event, ok := b.changes[path]; ok && !emitted[path] {
+			emitted[path] = true
+			events = append(events, *event)
+		}
+	}
+
+	paired := make([]bool, len(events))
+	for i := range events {
+		if !events[i].renameSource {
+			continue
+		}
+		for j := i + 1; j < len(events); j++ {
+			if !paired[j] && events[j].Type == "created" && events[j].NodeType == events[i].NodeType {
+				events[i] = fileEvent{Type: "renamed", Path: events[j].Path, OldPath: events[i].Path, NodeType: events[j].NodeType}
+				paired[j] = true
+				break
+			}
+		}
+	}
+
+	kept := events[:0]
+	for i, event := range events {
+		if !paired[i] {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// writeSSE writes one server-sent event with a JSON payload and flushes it.
+// Events with a non-zero id can be resumed from.
+func writeSSE(w http.ResponseWriter, id uint64, name string, data any) {
+	payload, _ := json.Marshal(data)
+	if id != 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+	w.(http.Flusher).Flush()
+}
+
+// writeSnapshot sends the full tree, on connect and whenever the client has
+// to resync
+func writeSnapshot(w http.ResponseWriter, id uint64, root string, options vfs.TreeOptions) {
+	tree, _, err := vfs.GetTreeLazy(root, options)
+	if err != nil {
+		log.Printf("Failed to get tree for snapshot: %v", err)
+		return
+	}
+	writeSSE(w, id, "snapshot", tree)
+}
+
+// writeUpdate sends a batch as typed events, or a snapshot when the batch
+// asks for a resync
+func writeUpdate(w http.ResponseWriter, update watchUpdate, root string, options vfs.TreeOptions) {
+	if update.resync {
+		writeSnapshot(w, update.id, root, options)
+	}
+	for _, event := range update.events {
+		writeSSE(w, event.id, event.Type, event)
+	}
+	for _, change := range update.contents {
+		writeSSE(w, 0, "contentChanged", change)
+	}
+}
+
+func handleFileWatch(w http.ResponseWriter, r *http.Request) {
+	// Set headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Get root path from query parameter or environment variable
+	root := workspaceRoot(r)
+	if env := os.Getenv("NANO_IDE_ROOT"); env != "" && r.URL.Query().Get("root") == "" {
+		root = vfs.CleanRoot(env)
+	}
+
+	hub, err := acquireWatchHub(root)
+	if err != nil {
+		log.Printf("ERROR: failed to watch root %s: %v", root, err)
+		fmt.Fprintf(w, "event: error\ndata: Failed to setup file watching for %s: %v\n\n", root, err)
+		w.(http.Flusher).Flush()
+		return
+	}
+	defer hub.release()
+	lastID, resume := lastEventID(r)
+	client, missed, replayed, snapshotID := hub.subscribe(lastID, resume)
+	defer hub.unsubscribe(client)
+
+	// Send initial connection event with the client ID used to register
+	// expanded folders, then what a reconnecting client missed or the tree
+	// to start from
+	writeSSE(w, 0, "connected", map[string]any{"client": client.id, "watcher": hub.mode(), "resumed": replayed})
+	options := treeOptions(r)
+	if replayed {
+		writeUpdate(w, watchUpdate{events: missed}, root, options)
+	} else {
+		writeSnapshot(w, snapshotID, root, options)
+	}
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	// This loop is the only writer to w
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			w.(http.Flusher).Flush()
+		case <-client.resync:
+			// Queued updates predate the snapshot
+			id := hub.lastEventID()
+			for len(client.updates) > 0 {
+				<-client.updates
+			}
+			writeSnapshot(w, id, root, options)
+			writeUpdate(w, watchUpdate{contents: hub.recheckFiles(client)}, root, options)
+		case update := <-client.updates:
+			writeUpdate(w, update, root, options)
+		}
+	}
+}
+
+// addLazyWatch adds directories to watcher up to a specified depth
+func addLazyWatch(watcher *fsnotify.Watcher, root string, ignore *vfs.Ignorer, maxDepth int) error {
+	processed := make(map[string]bool)
+	watchCount := 0
+
+	var walkDir func(string, int) error
+	walkDir = func(path string, depth int) error {
+		if depth > maxDepth {
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if relPath, err := filepath.Rel(root, path); err == nil && ignore.Ignored(filepath.ToSlash(relPath), true) {
+			return nil
+		}
+
+		if processed[path] {
+			return nil
+		}
+		processed[path] = true
+
+		if watchCount >= maxWatches {
+			return fmt.Errorf("%w: more than %d directories", errWatchBudget, maxWatches)
+		}
+
+		if err := watcher.Add(path); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				return fmt.Errorf("%w: %v", errWatchBudget, err)
+			}
+			log.Printf("Warning: failed to add watch for %s: %v", path, err)
+			return nil
+		}
+		watchCount++
+
+		// Read directory contents
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil
+		}
+
+		// Recursively process subdirectories
+		for _, entry := range entries {
+			if entry.IsDir() {
+				subPath := filepath.Join(path, entry.Name())
+				if err := walkDir(subPath, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return walkDir(root, 0)
+}
@@ -2,7 +2,8 @@
 'use client'
 
 import React, { useState, useRef, useEffect, useCallback } from 'react'
-import { FileNode } from '@/types/file'
+import { FileChange, FileNode } from '@/types/file'
+import { applyFileChange } from '@/lib/file-tree'
 import {
   Folder,
   File,
@@ -119,6 +120,7 @@ interface FileExplorerProps {
   isMinimized?: boolean
   showMinimizeButton?: boolean
   activeFilePath?: string | null
+  rootCursor?: string
 }
 
 interface CreateState {
@@ -147,7 +149,8 @@ export function FileExplorer({
   onMinimize,
   isMinimized = false,
   showMinimizeButton = true,
-  activeFilePath = null
+  activeFilePath = null,
+  rootCursor
 }: FileExplorerProps) {
   const [expandedFolders, setExpandedFolders] = useState<Set<string>>(new Set())
   const [contextMenu, setContextMenu] = useState<{ x: number; y: number; node: FileNode | null } | null>(null)
@@ -157,8 +160,11 @@ export function FileExplorer({
   const [deleteNode, setDeleteNode] = useState<FileNode | null>(null)
   const [errorMsg, setErrorMsg] = useState<string | null>(null)
   const [localTree, setLocalTree] = useState<FileNode[]>(tree)
+  const [rootNextCursor, setRootNextCursor] = useState<string | undefined>(rootCursor)
   const [pathInput, setPathInput] = useState<string>(currentPath)
   const [isPathEditing, setIsPathEditing] = useState<boolean>(false)
+  const [watchClientId, setWatchClientId] = useState<string | null>(null)
+  const watchedFoldersRef = useRef<{ client: string | null; folders: Set<string> }>({ client: null, folders: new Set() })
 
   const explorerRef = useRef<HTMLDivElement>(null)
   const contextMenuRef = useRef<HTMLDivElement>(null)
@@ -171,6 +177,52 @@ export function FileExplorer({
     setLocalTree(tree)
   }, [tree])
 
+  // Apply changes from the watch stream without reloading the tree
+  useEffect(() => {
+    const handleFileChange = (event: Event) => {
+      const change = (event as CustomEvent<FileChange>).detail
+      setLocalTree(prevTree => applyFileChange(prevTree, change))
+    }
+    window.addEventListener('fileChange', handleFileChange)
+    return () => window.removeEventListener('fileChange', handleFileChange)
+  }, [])
+
+  // Each watch stream connection gets a new client ID
+  useEffect(() => {
+    const handleConnected = (event: Event) => {
+      setWatchClientId((event as CustomEvent<string>).detail)
+    }
+    window.addEventListener('watchConnected', handleConnected)
+    return () => window.removeEventListener('watchConnected', handleConnected)
+  }, [])
+
+  // Register expanded folders with the watcher so their contents stay live,
+  // and release collapsed ones. A new client ID starts from scratch.
+  useEffect(() => {
+    if (!watchClientId) return
+    const watchFolder = (path: string, action: 'expand' | 'collapse') => {
+      fetch(`${config.apiEndpoint}/api/${action}?root=${encodeURIComponent(currentPath)}`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ path, client: watchClientId })
+      }).catch(error => console.error(`Failed to ${action} folder watch:`, error))
+    }
+    const watched = watchedFoldersRef.current.client === watchClientId
+      ? watchedFoldersRef.current.folders
+      : new Set<string>()
+    expandedFolders.forEach(path => {
+      if (!watched.has(path)) watchFolder(path, 'expand')
+    })
+    watched.forEach(path => {
+      if (!expandedFolders.has(path)) watchFolder(path, 'collapse')
+    })
+    watchedFoldersRef.current = { client: watchClientId, folders: new Set(expandedFolders) }
+  }, [expandedFolders, watchClientId, currentPath])
+
+  useEffect(() => {
+    setRootNextCursor(rootCursor)
+  }, [rootCursor])
+
   // Sync path input with currentPath
   useEffect(() => {
     setPathInput(currentPath)
@@ -216,42 +268,60 @@ export function FileExplorer({
     return normalizePath(`${parentPath}/${name}`)
   }, [])
 
-  const loadDirectoryContents = useCallback(async (folderPath: string) => {
+  // Loads a page of a folder's children; a cursor appends the next page
+  const loadDirectoryContents = useCallback(async (folderPath: string, cursor?: string) => {
     try {
       const response = await fetch(
-        `${config.apiEndpoint}/api/files?root=${encodeURIComponent(currentPath)}&path=${encodeURIComponent(folderPath)}`
+        `${config.apiEndpoint}/api/files?root=${encodeURIComponent(currentPath)}&path=${encodeURIComponent(folderPath)}${config.showIgnored ? '&showIgnored=true' : ''}${cursor ? `&cursor=${encodeURIComponent(cursor)}` : ''}`
       )
       if (response.ok) {
         const children = await response.json() as FileNode[] | null
-        setLocalTree(prevTree => updateTreeWithChildren(prevTree, folderPath, children || []))
+        const nextCursor = response.headers.get('X-Next-Cursor') || undefined
+        if (folderPath === '/') {
+          setLocalTree(prevTree => [...prevTree, ...(children || [])])
+          setRootNextCursor(nextCursor)
+          return
+        }
+        setLocalTree(prevTree => updateTreeWithChildren(prevTree, folderPath, children || [], nextCursor, !!cursor))
       }
     } catch {
       // silently ignore lazy load errors
     }
   }, [currentPath])
 
-  const updateTreeWithChildren = (tree: FileNode[], folderPath: string, children: FileNode[]): FileNode[] => {
-    return tree.map(node => updateNodeWithChildren(node, folderPath, children))
+  const updateTreeWithChildren = (tree: FileNode[], folderPath: string, children: FileNode[], nextCursor: string | undefined, append: boolean): FileNode[] => {
+    return tree.map(node => updateNodeWithChildren(node, folderPath, children, nextCursor, append))
   }
 
-  const updateNodeWithChildren = (node: FileNode, folderPath: string, children: FileNode[]): FileNode => {
+  const updateNodeWithChildren = (node: FileNode, folderPath: string, children: FileNode[], nextCursor: string | undefined, append: boolean): FileNode => {
     if (node.path === folderPath) {
       return {
         ...node,
-        children: children || [],
+        children: append ? [...(node.children || []), ...children] : children,
         loaded: true,
-        hasMore: children && children.length >= 100
+        hasMore: Boolean(nextCursor),
+        nextCursor
       }
     }
     if (node.children) {
       return {
         ...node,
-        children: node.children.map(child => updateNodeWithChildren(child, folderPath, children))
+        children: node.children.map(child => updateNodeWithChildren(child, folderPath, children, nextCursor, append))
       }
     }
     return node
   }
 
+  const renderLoadMore = (folderPath: string, cursor: string, depth: number) => (
+    <div
+      className="text-[#61afef] text-xs h-[22px] flex items-center cursor-pointer hover:bg-[#252a32]"
+      style={{ paddingLeft: `${8 + depth * 16}px` }}
+      onClick={() => loadDirectoryContents(folderPath, cursor)}
+    >
+      Load more…
+    </div>
+  )
+
   const expandFolder = useCallback(async (folderPath: string) => {
     await loadDirectoryContents(folderPath)
   }, [loadDirectoryContents])
@@ -463,7 +533,7 @@ export function FileExplorer({
       // visually distinct but clearly in the same "selection" family.
       const isContextSelected = node.path === contextMenuNodePath
 
-      const isHidden = node.name.startsWith('.')
+      const isHidden = node.name.startsWith('.') || node.ignored
 
       return (
         <div key={node.path} style={{ opacity: isCut ? 0.4 : isHidden ? 0.5 : 1 }}>
@@ -602,7 +672,10 @@ export function FileExplorer({
           {node.type === 'folder' && isExpanded && node.children && (
             <div>
               {node.children.length > 0 ? (
-                renderTree(node.children, depth + 1)
+                <>
+                  {renderTree(node.children, depth + 1)}
+                  {node.nextCursor && renderLoadMore(node.path, node.nextCursor, depth + 1)}
+                </>
               ) : (
                 <div
                   className="text-[#5c6370] text-xs italic h-[22px] flex items-center"
@@ -628,6 +701,7 @@ export function FileExplorer({
     sortNodes,
     clipboard,
     contextMenuNodePath,   // re-render when context-menu target changes
+    loadDirectoryContents,
   ])
 
   return (
@@ -726,6 +800,7 @@ export function FileExplorer({
             ) : (
               <>
                 {renderTree(localTree)}
+                {rootNextCursor && renderLoadMore('/', rootNextCursor, 0)}
                 {/* Root-level create input */}
                 {createState?.isActive && createState.parentPath === null && (
                   <div className="px-2 py-[2px]">
@@ -12,9 +12,10 @@ import {
 import { FileExplorer } from "@/components/FileExplorer";
 import { Editor, MarkerData } from "@/components/Editor";
 import { SearchPanel } from "@/components/SearchPanel";
+import { QuickOpen } from "@/components/QuickOpen";
 import { TabBar } from "@/components/TabBar";
 import { ResizablePanel } from "@/components/ResizablePanel";
-import { FileNode } from "@/types/file";
+import { ContentChange, FileChange, FileNode } from "@/types/file";
 import { useSearchParams } from "next/navigation";
 import dynamic from "next/dynamic";
 import { config } from "@/utils/config";
@@ -40,8 +41,9 @@ const DEFAULT_SIDEBAR_WIDTH = 200;
 
 export function HomeContent() {
   const [tree, setTree] = useState<FileNode[]>([]);
+  const [rootCursor, setRootCursor] = useState<string | undefined>();
   const [tabs, setTabs] = useState<
-    Map<string, { content: string; dirty: boolean }>
+    Map<string, { content: string; dirty: boolean; hash?: string }>
   >(new Map());
   const [activeTab, setActiveTab] = useState<string | null>(null);
   const [currentPath, setCurrentPath] = useState<string>(".");
@@ -59,6 +61,7 @@ export function HomeContent() {
   );
   const [lastExplorerWidth, setLastExplorerWidth] = useState(DEFAULT_SIDEBAR_WIDTH);
   const [activePanel, setActivePanel] = useState<string>("files");
+  const [isQuickOpen, setIsQuickOpen] = useState(false);
   const [markers, setMarkers] = useState<MarkerData[]>([]);
   const [searchTarget, setSearchTarget] = useState<{
     path: string;
@@ -66,6 +69,11 @@ export function HomeContent() {
     column: number;
   } | null>(null);
   const tabsRef = useRef(tabs);
+  const [watchClientId, setWatchClientId] = useState<string | null>(null);
+  const subscribedRef = useRef<{ client: string | null; paths: Set<string> }>({
+    client: null,
+    paths: new Set(),
+  });
   const autosaveInFlightRef = useRef<Set<string>>(new Set());
   const searchParams = useSearchParams();
 
@@ -123,6 +131,12 @@ export function HomeContent() {
         });
       }
 
+      // Ctrl+P — quick open
+      if ((e.ctrlKey || e.metaKey) && !e.shiftKey && e.key.toLowerCase() === "p") {
+        e.preventDefault();
+        if (config.showEditor) setIsQuickOpen(true);
+      }
+
       // Ctrl+Shift+F — workspace search
       if ((e.ctrlKey || e.metaKey) && e.shiftKey && e.key.toLowerCase() === "f") {
         e.preventDefault();
@@ -141,22 +155,69 @@ export function HomeContent() {
   // SSE for real-time file tree updates
   useEffect(() => {
     let eventSource: EventSource | null = null;
+    // Reconnects resume after the last event seen instead of reloading the tree
+    let lastEventId = "";
 
     const connectSSE = () => {
       if (eventSource) eventSource.close();
 
       eventSource = new EventSource(
-        `${config.apiEndpoint}/api/watch?root=${encodeURIComponent(currentPath)}`,
+        `${config.apiEndpoint}/api/watch?root=${encodeURIComponent(currentPath)}${config.showIgnored ? "&showIgnored=true" : ""}${lastEventId ? `&lastEventId=${lastEventId}` : ""}`,
       );
 
-      eventSource.onmessage = (event) => {
+      // The explorer registers expanded folders under this client ID
+      eventSource.addEventListener("connected", (event) => {
         try {
-          const newTree = JSON.parse(event.data) as FileNode[];
-          setTree(newTree);
+          const { client } = JSON.parse((event as MessageEvent).data) as { client: string };
+          setWatchClientId(client);
+          window.dispatchEvent(new CustomEvent("watchConnected", { detail: client }));
         } catch (error) {
           console.error("Error parsing SSE data:", error);
         }
-      };
+      });
+
+      // A snapshot replaces the tree; typed changes are applied in place
+      eventSource.addEventListener("snapshot", (event) => {
+        lastEventId = (event as MessageEvent).lastEventId;
+        try {
+          setTree(JSON.parse((event as MessageEvent).data) as FileNode[]);
+        } catch (error) {
+          console.error("Error parsing SSE data:", error);
+        }
+      });
+      for (const type of ["created", "modified", "deleted", "renamed"]) {
+        eventSource.addEventListener(type, (event) => {
+          lastEventId = (event as MessageEvent).lastEventId;
+          try {
+            const change = JSON.parse((event as MessageEvent).data) as FileChange;
+            window.dispatchEvent(new CustomEvent("fileChange", { detail: change }));
+          } catch (error) {
+            console.error("Error parsing SSE data:", error);
+          }
+        });
+      }
+
+      // Open files rewritten on disk reload unless they have unsaved edits
+      eventSource.addEventListener("contentChanged", (event) => {
+        try {
+          const change = JSON.parse((event as MessageEvent).data) as ContentChange;
+          const path = normalizePath(change.path);
+          setTabs((prevTabs) => {
+            const tab = prevTabs.get(path);
+            if (!tab || tab.hash === change.hash || change.content === undefined) return prevTabs;
+            if (tab.content === change.content) return prevTabs;
+            if (tab.dirty) {
+              console.warn(`${path} changed on disk while it has unsaved edits`);
+              return prevTabs;
+            }
+            const newTabs = new Map(prevTabs);
+            newTabs.set(path, { content: change.content, dirty: false, hash: change.hash });
+            return newTabs;
+          });
+        } catch (error) {
+          console.error("Error parsing SSE data:", error);
+        }
+      });
 
       eventSource.onerror = (error) => {
         console.error("SSE error:", error);
@@ -216,12 +277,13 @@ export function HomeContent() {
   const refreshTree = async () => {
     try {
       const response = await fetch(
-        `${config.apiEndpoint}/api/files?root=${encodeURIComponent(currentPath)}`,
+        `${config.apiEndpoint}/api/files?root=${encodeURIComponent(currentPath)}${config.showIgnored ? "&showIgnored=true" : ""}`,
       );
       if (!response.ok)
         throw new Error(`HTTP ${response.status}: ${response.statusText}`);
       const data = await response.json();
       setTree(data);
+      setRootCursor(response.headers.get("X-Next-Cursor") || undefined);
     } catch (error) {
       console.error("Failed to load file tree:", error);
     }
@@ -240,8 +302,9 @@ export function HomeContent() {
       if (!response.ok)
         throw new Error(`HTTP ${response.status}: ${response.statusText}`);
       const content = await response.text();
+      const hash = response.headers.get("X-Content-Hash") ?? undefined;
       const newTabs = new Map(tabs);
-      newTabs.set(tabPath, { content, dirty: false });
+      newTabs.set(tabPath, { content, dirty: false, hash });
       setTabs(newTabs);
       setActiveTab(tabPath);
     } catch (error) {
@@ -249,6 +312,30 @@ export function HomeContent() {
     }
   };
 
+  // Subscribe open tabs to content changes on the watch stream; a new client
+  // ID starts from scratch
+  useEffect(() => {
+    if (!watchClientId) return;
+    const subscribe = (path: string, action: "subscribe" | "unsubscribe", hash?: string) => {
+      fetch(`${config.apiEndpoint}/api/${action}?root=${encodeURIComponent(currentPath)}`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ path: "/" + path, client: watchClientId, hash, content: true }),
+      }).catch((error) => console.error(`Failed to ${action} file changes:`, error));
+    };
+    const subscribed =
+      subscribedRef.current.client === watchClientId
+        ? subscribedRef.current.paths
+        : new Set<string>();
+    tabs.forEach((tab, path) => {
+      if (!subscribed.has(path)) subscribe(path, "subscribe", tab.hash);
+    });
+    subscribed.forEach((path) => {
+      if (!tabs.has(path)) subscribe(path, "unsubscribe");
+    });
+    subscribedRef.current = { client: watchClientId, paths: new Set(tabs.keys()) };
+  }, [tabs, watchClientId, currentPath]);
+
   const saveFile = async (path: string, content: string) => {
     try {
       const normalizedPath = path.startsWith("/") ? path : "/" + path;
@@ -326,7 +413,7 @@ export function HomeContent() {
       const tab = prevTabs.get(path);
       if (!tab) return prevTabs;
       const newTabs = new Map(prevTabs);
-      newTabs.set(path, { content, dirty: true });
+      newTabs.set(path, { ...tab, content, dirty: true });
       return newTabs;
     });
   };
@@ -494,6 +581,7 @@ export function HomeContent() {
                 onRefresh={refreshTree}
                 showMinimizeButton={false}
                 activeFilePath={activeTab}
+                rootCursor={rootCursor}
               />
             )}
           </div>
@@ -583,6 +671,7 @@ export function HomeContent() {
             showResizeHandle={config.showEditor && !isTerminalMinimized}
           >
             <TerminalPanel
+              root={currentPath}
               onMaximize={handleTerminalMaximize}
               onMinimize={handleTerminalMinimizeToggle}
               isMinimized={isTerminalMinimized}
@@ -592,6 +681,14 @@ export function HomeContent() {
 
 
       </div>
+
+      {isQuickOpen && (
+        <QuickOpen
+          currentPath={currentPath}
+          onFileOpen={(path) => openFile(path)}
+          onClose={() => setIsQuickOpen(false)}
+        />
+      )}
     </div>
   );
 }
@@ -0,0 +1,129 @@
+'use client'
+
+import React, { useEffect, useRef, useState } from 'react'
+import { config } from '@/utils/config'
+import { getFileIcon } from '@/components/FileExplorer'
+
+interface FindMatch {
+  path: string
+  name: string
+  score: number
+  positions: number[]
+}
+
+interface QuickOpenProps {
+  currentPath: string
+  onFileOpen: (path: string) => void
+  onClose: () => void
+}
+
+function highlight(text: string, offset: number, positions: Set<number>) {
+  return Array.from(text).map((char, i) =>
+    positions.has(offset + i) ? (
+      <span key={i} className="text-[#61afef] font-semibold">{char}</span>
+    ) : (
+      <span key={i}>{char}</span>
+    )
+  )
+}
+
+export function QuickOpen({ currentPath, onFileOpen, onClose }: QuickOpenProps) {
+  const [query, setQuery] = useState('')
+  const [matches, setMatches] = useState<FindMatch[]>([])
+  const [selected, setSelected] = useState(0)
+  const inputRef = useRef<HTMLInputElement>(null)
+
+  useEffect(() => {
+    inputRef.current?.focus()
+  }, [])
+
+  useEffect(() => {
+    if (!query.trim()) {
+      setMatches([])
+      return
+    }
+
+    const controller = new AbortController()
+    const timer = window.setTimeout(async () => {
+      try {
+        const params = new URLSearchParams({ root: currentPath, q: query })
+        const response = await fetch(`${config.apiEndpoint}/api/find?${params.toString()}`, {
+          signal: controller.signal,
+        })
+        if (!response.ok) return
+        setMatches((await response.json()) as FindMatch[])
+        setSelected(0)
+      } catch {
+        // aborted or offline; keep previous results
+      }
+    }, 50)
+
+    return () => {
+      controller.abort()
+      window.clearTimeout(timer)
+    }
+  }, [currentPath, query])
+
+  const open = (match: FindMatch | undefined) => {
+    if (!match) return
+    onFileOpen(match.path)
+    onClose()
+  }
+
+  const handleKeyDown = (e: React.KeyboardEvent) => {
+    if (e.key === 'Escape') {
+      e.preventDefault()
+      onClose()
+    } else if (e.key === 'ArrowDown') {
+      e.preventDefault()
+      setSelected((prev) => Math.min(prev + 1, matches.length - 1))
+    } else if (e.key === 'ArrowUp') {
+      e.preventDefault()
+      setSelected((prev) => Math.max(prev - 1, 0))
+    } else if (e.key === 'Enter') {
+      e.preventDefault()
+      open(matches[selected])
+    }
+  }
+
+  return (
+    <div className="fixed inset-0 z-50 flex justify-center pt-16" onMouseDown={onClose}>
+      <div
+        className="w-[560px] max-w-[90vw] h-fit bg-[#21252b] border border-[#181a1f] rounded shadow-lg overflow-hidden"
+        onMouseDown={(e) => e.stopPropagation()}
+      >
+        <input
+          ref={inputRef}
+          value={query}
+          onChange={(e) => setQuery(e.target.value)}
+          onKeyDown={handleKeyDown}
+          placeholder="Search files by name"
+          className="w-full bg-[#1b1f23] text-[#abb2bf] text-sm px-3 py-2 outline-none border-b border-[#181a1f]"
+        />
+        <div className="max-h-[50vh] overflow-y-auto">
+          {matches.map((match, index) => {
+            const positions = new Set(match.positions)
+            const dir = match.path.slice(0, match.path.length - match.name.length)
+            return (
+              <div
+                key={match.path}
+                className={`flex items-center h-[26px] px-3 text-sm cursor-pointer ${index === selected ? 'bg-[#2c313a] text-white' : 'text-[#abb2bf] hover:bg-[#252a32]'}`}
+                onMouseEnter={() => setSelected(index)}
+                onClick={() => open(match)}
+              >
+                <div className="flex-shrink-0 mr-2">{getFileIcon(match.name)}</div>
+                <span className="flex-shrink-0">{highlight(match.name, dir.length, positions)}</span>
+                <span className="ml-2 text-xs text-[#5c6370] truncate">{highlight(dir, 0, positions)}</span>
+              </div>
+            )
+          })}
+          {query.trim() && matches.length === 0 && (
+            <div className="text-[#5c6370] text-xs italic h-[26px] px-3 flex items-center">
+              No matching files
+            </div>
+          )}
+        </div>
+      </div>
+    </div>
+  )
+}
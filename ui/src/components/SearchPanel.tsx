@@ -4,6 +4,7 @@ import React, { useEffect, useMemo, useState } from 'react'
 import {
   BookOpen,
   CaseSensitive,
+  CaseUpper,
   ChevronDown,
   Copy,
   Ellipsis,
@@ -14,6 +15,7 @@ import {
   ReplaceAll,
   Settings,
   WholeWord,
+  WrapText,
 } from 'lucide-react'
 import { config } from '@/utils/config'
 import { cn } from '@/lib/utils'
@@ -22,6 +24,7 @@ import { getFileIcon } from '@/components/FileExplorer'
 interface SearchMatch {
   line: number
   column: number
+  endLine: number
   endColumn: number
   lineText: string
   previewStart: number
@@ -39,10 +42,43 @@ interface SearchResult {
   limitHit: boolean
 }
 
+interface SearchEvent {
+  type: 'file' | 'done' | 'error'
+  file?: SearchFileResult
+  matches?: number
+  limitHit?: boolean
+  error?: string
+}
+
 interface ReplaceResult {
   files: number
   replacements: number
   paths: string[]
+  conflicts?: string[]
+  skipped?: string[]
+  changeset?: string
+}
+
+interface ReplaceMatch {
+  id: string
+  line: number
+  column: number
+  endColumn: number
+  text: string
+  replacement: string
+}
+
+interface ReplaceFilePreview {
+  path: string
+  hash: string
+  diff: string
+  matches: ReplaceMatch[]
+}
+
+interface ReplacePreview {
+  files: ReplaceFilePreview[]
+  matches: number
+  limitHit: boolean
 }
 
 interface SearchPanelProps {
@@ -66,6 +102,8 @@ export function SearchPanel({
   const [caseSensitive, setCaseSensitive] = useState(false)
   const [wholeWord, setWholeWord] = useState(false)
   const [useRegex, setUseRegex] = useState(false)
+  const [multiline, setMultiline] = useState(false)
+  const [preserveCase, setPreserveCase] = useState(false)
   const [result, setResult] = useState<SearchResult>({
     files: [],
     matches: 0,
@@ -76,6 +114,10 @@ export function SearchPanel({
   const [error, setError] = useState<string | null>(null)
   const [message, setMessage] = useState<string | null>(null)
   const [refreshVersion, setRefreshVersion] = useState(0)
+  const [preview, setPreview] = useState<ReplacePreview | null>(null)
+  const [skipped, setSkipped] = useState<Set<string>>(new Set())
+  const [openDiffs, setOpenDiffs] = useState<Set<string>>(new Set())
+  const [changeset, setChangeset] = useState<string | null>(null)
 
   const hasQuery = query.trim().length > 0
 
@@ -88,9 +130,16 @@ export function SearchPanel({
       caseSensitive: String(caseSensitive),
       wholeWord: String(wholeWord),
       regex: String(useRegex),
+      multiline: String(multiline),
+      stream: 'true',
     })
     return `${config.apiEndpoint}/api/search?${params.toString()}`
-  }, [caseSensitive, currentPath, excludeFiles, includeFiles, query, useRegex, wholeWord])
+  }, [caseSensitive, currentPath, excludeFiles, includeFiles, multiline, query, useRegex, wholeWord])
+
+  // A preview is only valid for the query and replacement it was made with
+  useEffect(() => {
+    setPreview(null)
+  }, [searchUrl, replaceText, preserveCase])
 
   useEffect(() => {
     if (!hasQuery) {
@@ -106,7 +155,39 @@ export function SearchPanel({
         setError(null)
         const response = await fetch(searchUrl, { signal: controller.signal })
         if (!response.ok) throw new Error(await response.text())
-        setResult((await response.json()) as SearchResult)
+        setResult({ files: [], matches: 0, limitHit: false })
+
+        // Results arrive as newline-delimited JSON, one line per file
+        const reader = response.body!.getReader()
+        const decoder = new TextDecoder()
+        let buffered = ''
+        for (;;) {
+          const { done, value } = await reader.read()
+          if (done) break
+          buffered += decoder.decode(value, { stream: true })
+          const lines = buffered.split('\n')
+          buffered = lines.pop() ?? ''
+
+          const files: SearchFileResult[] = []
+          for (const line of lines) {
+            if (!line.trim()) continue
+            const event = JSON.parse(line) as SearchEvent
+            if (event.type === 'file' && event.file) {
+              files.push(event.file)
+            } else if (event.type === 'done') {
+              setResult((prev) => ({ ...prev, limitHit: !!event.limitHit }))
+            } else if (event.type === 'error') {
+              throw new Error(event.error)
+            }
+          }
+          if (files.length > 0) {
+            setResult((prev) => ({
+              files: [...prev.files, ...files],
+              matches: prev.matches + files.reduce((sum, file) => sum + file.matches.length, 0),
+              limitHit: prev.limitHit,
+            }))
+          }
+        }
       } catch (err) {
         if ((err as Error).name !== 'AbortError') {
           setError(err instanceof Error ? err.message : 'Search failed')
@@ -122,43 +203,139 @@ export function SearchPanel({
     }
   }, [hasQuery, refreshVersion, searchUrl])
 
-  const replaceAll = async () => {
+  const replaceRequest = () => ({
+    query,
+    replace: replaceText,
+    include: includeFiles,
+    exclude: excludeFiles,
+    caseSensitive,
+    wholeWord,
+    useRegex,
+    multiline,
+    preserveCase,
+  })
+
+  // Replace All first asks for a preview; nothing is written until applied
+  const previewReplace = async () => {
     if (!hasQuery || isReplacing) return
     try {
       setIsReplacing(true)
       setError(null)
       setMessage(null)
+      setChangeset(null)
       const response = await fetch(
         `${config.apiEndpoint}/api/search?root=${encodeURIComponent(currentPath)}`,
         {
           method: 'POST',
           headers: { 'Content-Type': 'application/json' },
-          body: JSON.stringify({
-            query,
-            replace: replaceText,
-            include: includeFiles,
-            exclude: excludeFiles,
-            caseSensitive,
-            wholeWord,
-            useRegex,
-          }),
+          body: JSON.stringify({ ...replaceRequest(), dryRun: true }),
+        },
+      )
+      if (!response.ok) throw new Error(await response.text())
+      setPreview((await response.json()) as ReplacePreview)
+      setSkipped(new Set())
+      setOpenDiffs(new Set())
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Replace preview failed')
+    } finally {
+      setIsReplacing(false)
+    }
+  }
+
+  const applyReplace = async () => {
+    if (!preview || isReplacing) return
+    const hashes: Record<string, string> = {}
+    const matches: string[] = []
+    for (const file of preview.files) {
+      const selected = file.matches.filter((match) => !skipped.has(match.id))
+      if (selected.length === 0) continue
+      hashes[file.path] = file.hash
+      matches.push(...selected.map((match) => match.id))
+    }
+    if (matches.length === 0) {
+      setPreview(null)
+      return
+    }
+
+    try {
+      setIsReplacing(true)
+      setError(null)
+      const response = await fetch(
+        `${config.apiEndpoint}/api/search?root=${encodeURIComponent(currentPath)}`,
+        {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ ...replaceRequest(), hashes, matches }),
         },
       )
       if (!response.ok) throw new Error(await response.text())
       const data = (await response.json()) as ReplaceResult
       setMessage(`Replaced ${data.replacements} match${data.replacements === 1 ? '' : 'es'} in ${data.files} file${data.files === 1 ? '' : 's'}.`)
+      const problems: string[] = []
+      if (data.conflicts?.length) {
+        problems.push(`Skipped files changed since the preview:\n${data.conflicts.join('\n')}`)
+      }
+      if (data.skipped?.length) {
+        problems.push(`Skipped files that couldn't be decoded:\n${data.skipped.join('\n')}`)
+      }
+      if (problems.length) {
+        setError(problems.join('\n\n'))
+      }
       onReplaceComplete(data.paths || [])
+      setPreview(null)
       setResult({ files: [], matches: 0, limitHit: false })
+      // Keep the message up while the replace can still be undone
+      setChangeset(data.changeset || null)
+      if (!data.changeset) {
+        window.setTimeout(() => {
+          setMessage(null)
+        }, 3000)
+      }
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Replace failed')
+    } finally {
+      setIsReplacing(false)
+    }
+  }
+
+  const undoReplace = async () => {
+    if (!changeset || isReplacing) return
+    try {
+      setIsReplacing(true)
+      setError(null)
+      const response = await fetch(
+        `${config.apiEndpoint}/api/changesets/${encodeURIComponent(changeset)}/revert?root=${encodeURIComponent(currentPath)}`,
+        { method: 'POST' },
+      )
+      if (!response.ok) throw new Error(await response.text())
+      const data = (await response.json()) as ReplaceResult
+      setChangeset(null)
+      setMessage(`Reverted ${data.files} file${data.files === 1 ? '' : 's'}.`)
+      if (data.conflicts?.length) {
+        setError(`Skipped files edited since the replace:\n${data.conflicts.join('\n')}`)
+      }
+      onReplaceComplete(data.paths || [])
+      setRefreshVersion((value) => value + 1)
       window.setTimeout(() => {
         setMessage(null)
       }, 3000)
     } catch (err) {
-      setError(err instanceof Error ? err.message : 'Replace failed')
+      setError(err instanceof Error ? err.message : 'Undo failed')
     } finally {
       setIsReplacing(false)
     }
   }
 
+  const toggleSet = (set: Set<string>, key: string) => {
+    const next = new Set(set)
+    if (next.has(key)) {
+      next.delete(key)
+    } else {
+      next.add(key)
+    }
+    return next
+  }
+
   const rerunSearch = () => {
     if (!hasQuery) return
     setResult({ files: [], matches: 0, limitHit: false })
@@ -243,6 +420,9 @@ export function SearchPanel({
               <ToggleButton active={useRegex} title="Use Regular Expression" onClick={() => setUseRegex((value) => !value)}>
                 <Regex className="w-3.5 h-3.5" />
               </ToggleButton>
+              <ToggleButton active={multiline} title="Match Across Lines" onClick={() => setMultiline((value) => !value)}>
+                <WrapText className="w-3.5 h-3.5" />
+              </ToggleButton>
             </div>
           </div>
         </div>
@@ -257,10 +437,13 @@ export function SearchPanel({
                 placeholder="Replace"
                 className="h-full flex-1 bg-transparent outline-none text-[13px] text-[#abb2bf] px-2 min-w-0 placeholder:text-[#5c6370]"
               />
+              <ToggleButton active={preserveCase} title="Preserve Case" onClick={() => setPreserveCase((value) => !value)}>
+                <CaseUpper className="w-3.5 h-3.5" />
+              </ToggleButton>
               <button
                 title="Replace All"
                 disabled={!hasQuery || isReplacing}
-                onClick={replaceAll}
+                onClick={previewReplace}
                 className="h-7 w-7 flex items-center justify-center text-[#abb2bf] hover:bg-[#303641] hover:text-white disabled:text-[#5c6370] disabled:cursor-not-allowed"
               >
                 <ReplaceAll className="w-3.5 h-3.5" />
@@ -287,7 +470,7 @@ export function SearchPanel({
 
       <div className="px-5 py-2 text-[13px] text-[#abb2bf] min-h-[34px] flex items-center">
         <div className="truncate">
-          {isSearching
+          {isSearching && result.matches === 0
             ? 'Searching...'
             : hasQuery
               ? `${result.matches} result${result.matches === 1 ? '' : 's'} in ${result.files.length} file${result.files.length === 1 ? '' : 's'}`
@@ -310,13 +493,88 @@ export function SearchPanel({
         </div>
       )}
       {message && (
-        <div className="px-3 py-2 bg-[#1f2d23] border-b border-[#304a36] text-[#98c379] text-[12px]">
-          {message}
+        <div className="px-3 py-2 flex items-center gap-2 bg-[#1f2d23] border-b border-[#304a36] text-[#98c379] text-[12px]">
+          <span className="truncate">{message}</span>
+          {changeset && (
+            <button
+              className="ml-auto px-2 h-6 hover:bg-[#304a36] disabled:opacity-50"
+              disabled={isReplacing}
+              onClick={undoReplace}
+            >
+              Undo
+            </button>
+          )}
+        </div>
+      )}
+
+      {preview && (
+        <div className="px-3 py-1.5 flex items-center gap-2 border-b border-[#191d23] text-[12px] text-[#abb2bf]">
+          <span className="truncate">
+            {preview.matches - skipped.size} of {preview.matches} replacement{preview.matches === 1 ? '' : 's'} selected
+            {preview.limitHit && <span className="text-[#e5c07b]"> Limit reached.</span>}
+          </span>
+          <button
+            className="ml-auto px-2 h-6 bg-[#61afef] text-[#1f2329] hover:bg-[#7dbef2] disabled:opacity-50"
+            disabled={isReplacing}
+            onClick={applyReplace}
+          >
+            Apply
+          </button>
+          <button className="px-2 h-6 hover:bg-[#303641]" onClick={() => setPreview(null)}>
+            Cancel
+          </button>
         </div>
       )}
 
       <div className="flex-1 overflow-y-auto scrollbar-thin py-1">
-        {result.files.map((file) => {
+        {preview && preview.files.map((file) => {
+          const meta = getFileMeta(file.path)
+          const diffOpen = openDiffs.has(file.path)
+          return (
+          <div key={file.path}>
+            <button
+              className="w-full h-[24px] flex items-center gap-1 px-3 text-left text-[13px] text-[#abb2bf] hover:bg-[#252a32]"
+              onClick={() => setOpenDiffs((prev) => toggleSet(prev, file.path))}
+              title={diffOpen ? 'Hide diff' : 'Show diff'}
+            >
+              <ChevronDown className={cn('w-3.5 h-3.5 text-[#abb2bf] flex-shrink-0 transition-transform', !diffOpen && '-rotate-90')} />
+              <div className="flex-shrink-0">{getFileIcon(meta.name)}</div>
+              <span className="truncate font-medium text-[#abb2bf]">{meta.name}</span>
+              {meta.folder && <span className="truncate text-[#5c6370]">{meta.folder}</span>}
+            </button>
+            {diffOpen && (
+              <pre className="mx-3 my-1 p-2 bg-[#171b21] text-[11px] leading-4 overflow-x-auto">
+                {file.diff.split('\n').map((line, index) => (
+                  <div
+                    key={index}
+                    className={line.startsWith('+') ? 'text-[#98c379]' : line.startsWith('-') ? 'text-[#e06c75]' : line.startsWith('@@') ? 'text-[#61afef]' : 'text-[#5c6370]'}
+                  >
+                    {line || ' '}
+                  </div>
+                ))}
+              </pre>
+            )}
+            {file.matches.map((match) => (
+              <label
+                key={match.id}
+                className="w-full h-[24px] flex items-center gap-2 pl-8 pr-3 text-[12px] text-[#abb2bf] hover:bg-[#252a32] cursor-pointer"
+              >
+                <input
+                  type="checkbox"
+                  checked={!skipped.has(match.id)}
+                  onChange={() => setSkipped((prev) => toggleSet(prev, match.id))}
+                />
+                <span className="text-[#5c6370] flex-shrink-0">{match.line}:</span>
+                <span className="truncate">
+                  <del className="text-[#e06c75]">{match.text}</del>
+                  <ins className="text-[#98c379] no-underline">{match.replacement}</ins>
+                </span>
+              </label>
+            ))}
+          </div>
+          )
+        })}
+        {!preview && result.files.map((file) => {
           const meta = getFileMeta(file.path)
           return (
           <div key={file.path}>
@@ -9,7 +9,7 @@ import { Terminal as XTerminal } from '@xterm/xterm'
 import '@xterm/xterm/css/xterm.css'
 import { config } from '@/utils/config'
 
-export function Terminal({ id }: { id: string }) {
+export function Terminal({ id, root = '.', profile }: { id: string; root?: string; profile?: string }) {
   const termRef = useRef<HTMLDivElement | null>(null)
   const terminal = useRef<XTerminal | null>(null)
   const fitAddon = useRef<FitAddon | null>(null)
@@ -153,65 +153,119 @@ export function Terminal({ id }: { id: string }) {
     })
     resizeObserver.observe(termRef.current)
 
-    // Use config WebSocket host for terminal connection
-    const url = `${config.wsHost}/terminal`
-    const socket = new WebSocket(url)
-    socket.binaryType = 'arraybuffer'
-    socketRef.current = socket
-
-    socket.onopen = () => {
-      if (isDisposed) {
-        return
+    // The server keeps the shell running when the connection drops, so reconnect
+    // to the same session and let it replay the output we missed.
+    const storageKey = `terminalSession:${id}`
+    let sessionId = sessionStorage.getItem(storageKey)
+    let exited = false
+    let retryDelay = 500
+    let retryTimeout: NodeJS.Timeout | null = null
+
+    const connect = () => {
+      // Use config WebSocket host for terminal connection
+      const reattaching = sessionId
+      // New sessions start in the workspace root with its env files applied
+      const params = new URLSearchParams({ root })
+      if (profile) {
+        params.set('profile', profile)
       }
-      // term.writeln('Connected to terminal')
-      sendPtyResize(term.cols, term.rows)
-    }
-
-    socket.onmessage = (event) => {
-      if (isDisposed) {
-        return
+      const url = reattaching
+        ? `${config.wsHost}/terminal?session=${encodeURIComponent(reattaching)}`
+        : `${config.wsHost}/terminal?${params.toString()}`
+      const socket = new WebSocket(url)
+      socket.binaryType = 'arraybuffer'
+      socketRef.current = socket
+      let opened = false
+
+      socket.onopen = () => {
+        if (isDisposed) {
+          return
+        }
+        opened = true
+        retryDelay = 500
+        lastPtySizeRef.current = null
+        sendPtyResize(term.cols, term.rows)
       }
 
-      if (event.data instanceof ArrayBuffer) {
-        const uint8Array = new Uint8Array(event.data)
-        const text = new TextDecoder('utf-8').decode(uint8Array)
-        term.write(text)
-      } else if (typeof event.data === 'string') {
-        term.write(event.data)
-      } else if (event.data instanceof Blob) {
-        event.data.arrayBuffer().then(buffer => {
-          if (isDisposed) {
-            return
-          }
+      socket.onmessage = (event) => {
+        if (isDisposed) {
+          return
+        }
 
-          const uint8Array = new Uint8Array(buffer)
+        if (event.data instanceof ArrayBuffer) {
+          const uint8Array = new Uint8Array(event.data)
           const text = new TextDecoder('utf-8').decode(uint8Array)
           term.write(text)
-        })
-      }
-    }
-
-    socket.onerror = (err) => {
-      if (isDisposed) {
-        return
+        } else if (typeof event.data === 'string') {
+          // Text messages are control messages; output is always binary
+          let message: { type?: string; id?: string; code?: number; message?: string }
+          try {
+            message = JSON.parse(event.data)
+          } catch {
+            term.write(event.data)
+            return
+          }
+          if (message.type === 'session' && message.id) {
+            if (reattaching === message.id) {
+              // The server replays its scrollback next
+              term.reset()
+            }
+            sessionId = message.id
+            sessionStorage.setItem(storageKey, message.id)
+          } else if (message.type === 'exit') {
+            exited = true
+            sessionStorage.removeItem(storageKey)
+            term.writeln(`\r\n[Process exited with code ${message.code ?? 0}]`)
+          } else if (message.type === 'error') {
+            // The server couldn't start the shell, so retrying won't help
+            exited = true
+            term.writeln(`\r\nFailed to start terminal: ${message.message ?? 'unknown error'}`)
+          }
+        } else if (event.data instanceof Blob) {
+          event.data.arrayBuffer().then(buffer => {
+            if (isDisposed) {
+              return
+            }
+
+            const uint8Array = new Uint8Array(buffer)
+            const text = new TextDecoder('utf-8').decode(uint8Array)
+            term.write(text)
+          })
+        }
       }
-      term.writeln('\r\nConnection error.')
-    }
 
-    socket.onclose = () => {
-      if (isDisposed) {
-        return
+      socket.onclose = () => {
+        if (isDisposed) {
+          return
+        }
+        if (exited) {
+          term.writeln('\r\nConnection closed.')
+          return
+        }
+        if (!opened && reattaching) {
+          // The session ended while we were away
+          sessionId = null
+          sessionStorage.removeItem(storageKey)
+          term.writeln('\r\nTerminal session ended, starting a new one.')
+          connect()
+          return
+        }
+        if (opened) {
+          term.writeln('\r\nConnection lost, reconnecting...')
+        }
+        retryTimeout = setTimeout(connect, retryDelay)
+        retryDelay = Math.min(retryDelay * 2, 10000)
       }
-      term.writeln('\r\nConnection closed.')
     }
+    connect()
 
     disposables.push(term.onData((data) => {
       if (isDisposed) {
         return
       }
 
-      if (socket.readyState === WebSocket.OPEN) {
-        socket.send(data)
+      if (socketRef.current?.readyState === WebSocket.OPEN) {
+        socketRef.current.send(data)
       }
     }))
 
@@ -259,6 +313,9 @@ export function Terminal({ id }: { id: string }) {
 
     return () => {
       isDisposed = true
+      if (retryTimeout) {
+        clearTimeout(retryTimeout)
+      }
       if (socketRef.current) {
         socketRef.current.onopen = null
         socketRef.current.onmessage = null
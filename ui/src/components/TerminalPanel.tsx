@@ -5,6 +5,7 @@ import { X, Plus, Maximize2, ChevronDown, ChevronUp } from 'lucide-react'
 import { FontAwesomeIcon } from '@fortawesome/react-fontawesome'
 import { faTerminal } from '@fortawesome/free-solid-svg-icons'
 import dynamic from 'next/dynamic'
+import { config } from '@/utils/config'
 
 const Terminal = dynamic(
   () => import('./Terminal').then(mod => ({ default: mod.Terminal })),
@@ -18,7 +19,8 @@ const Terminal = dynamic(
 
 interface TerminalInstance {
   id: string
-  label: string   // e.g. "zsh"
+  num: number
+  profile?: string  // Workspace default when unset
   cwd?: string    // e.g. "lab-images"
   pid?: number
 }
@@ -28,7 +30,15 @@ interface TerminalState {
   activeId: string
 }
 
+interface TerminalProfile {
+  name: string
+  command: string
+  args?: string[]
+  default?: boolean
+}
+
 interface TerminalPanelProps {
+  root?: string
   onMaximize?: () => void
   onMinimize?: () => void
   onClose?: () => void
@@ -40,13 +50,50 @@ interface TerminalPanelProps {
 
 // ─── Component ────────────────────────────────────────────────────────────────
 
-export function TerminalPanel({ onMaximize, onMinimize, onClose, isMaximized, isMinimized }: TerminalPanelProps) {
+export function TerminalPanel({ root = '.', onMaximize, onMinimize, onClose, isMaximized, isMinimized }: TerminalPanelProps) {
   const counterRef = useRef(1)
   const [terminalState, setTerminalState] = useState<TerminalState>({
-    instances: [{ id: 'term-1', label: 'zsh 1' }],
+    instances: [{ id: 'term-1', num: 1 }],
     activeId: 'term-1',
   })
   const { instances, activeId } = terminalState
+  const [profiles, setProfiles] = useState<TerminalProfile[]>([])
+  const [showProfiles, setShowProfiles] = useState(false)
+  const profileMenuRef = useRef<HTMLDivElement | null>(null)
+  const defaultProfile = profiles.find(profile => profile.default)?.name ?? 'zsh'
+
+  // Shells, REPLs and custom commands the workspace can start
+  useEffect(() => {
+    let cancelled = false
+    fetch(`${config.apiEndpoint}/api/terminals/profiles?root=${encodeURIComponent(root)}`)
+      .then(response => (response.ok ? response.json() : []))
+      .then((data: TerminalProfile[]) => {
+        if (!cancelled) setProfiles(data ?? [])
+      })
+      .catch(error => console.warn('Failed to load terminal profiles:', error))
+    return () => {
+      cancelled = true
+    }
+  }, [root])
+
+  // Close the profile menu when clicking outside it, or pressing Escape
+  useEffect(() => {
+    if (!showProfiles) return
+    const handleMouseDown = (event: MouseEvent) => {
+      if (profileMenuRef.current && !profileMenuRef.current.contains(event.target as Node)) {
+        setShowProfiles(false)
+      }
+    }
+    const handleKeyDown = (event: KeyboardEvent) => {
+      if (event.key === 'Escape') setShowProfiles(false)
+    }
+    document.addEventListener('mousedown', handleMouseDown)
+    document.addEventListener('keydown', handleKeyDown)
+    return () => {
+      document.removeEventListener('mousedown', handleMouseDown)
+      document.removeEventListener('keydown', handleKeyDown)
+    }
+  }, [showProfiles])
 
   // Auto-focus terminal when active instance changes
   useEffect(() => {
@@ -64,17 +111,29 @@ export function TerminalPanel({ onMaximize, onMinimize, onClose, isMaximized, is
     return () => window.removeEventListener('terminalPanelOpened', handler)
   }, [activeId])
 
-  const addInstance = () => {
+  const labelFor = (inst: TerminalInstance) => `${inst.profile ?? defaultProfile} ${inst.num}`
+
+  const addInstance = (profile?: string) => {
+    setShowProfiles(false)
     const num = ++counterRef.current
     const id = `term-${num}`
     setTerminalState(prev => ({
-      instances: [...prev.instances, { id, label: `zsh ${num}` }],
+      instances: [...prev.instances, { id, num, profile }],
       activeId: id,
     }))
   }
 
   const closeActiveInstance = (e: React.MouseEvent) => {
     e.stopPropagation()
+    if (instances.length === 1) return
+    // Closing the tab ends its shell on the server, and a later terminal with
+    // this id must not reattach to it
+    const sessionId = sessionStorage.getItem(`terminalSession:${activeId}`)
+    sessionStorage.removeItem(`terminalSession:${activeId}`)
+    if (sessionId) {
+      fetch(`${config.apiEndpoint}/api/terminals/${encodeURIComponent(sessionId)}`, { method: 'DELETE' })
+        .catch(error => console.warn('Failed to end terminal session:', error))
+    }
 
     setTerminalState(prev => {
       if (prev.instances.length === 1) return prev
@@ -128,11 +187,11 @@ export function TerminalPanel({ onMaximize, onMinimize, onClose, isMaximized, is
                     <span className="absolute left-1 right-1 bottom-0 h-[1px] bg-[#e5c07b]" />
                   )}
                   <FontAwesomeIcon icon={faTerminal} className="shrink-0 text-[10px] text-[#6f7784] w-[11px]" />
-                  <span className="truncate normal-case tracking-normal font-normal">{inst.label}</span>
+                  <span className="truncate normal-case tracking-normal font-normal">{labelFor(inst)}</span>
                   {isActive && instances.length > 1 && (
                     <button
                       type="button"
-                      aria-label={`Close ${inst.label}`}
+                      aria-label={`Close ${labelFor(inst)}`}
                       onClick={closeActiveInstance}
                       className="ml-0.5 flex h-4 w-4 shrink-0 items-center justify-center rounded text-[#7f8794] hover:bg-[#343b47] hover:text-[#d7dce5]"
                     >
@@ -145,11 +204,45 @@ export function TerminalPanel({ onMaximize, onMinimize, onClose, isMaximized, is
             <button
               type="button"
               title="New Terminal"
-              onClick={addInstance}
+              onClick={() => addInstance()}
               className="ml-0.5 flex h-5 w-5 shrink-0 items-center justify-center rounded text-[#828997] hover:text-[#d7dce5] hover:bg-[#252a32] transition-colors duration-100"
             >
               <Plus size={13} />
             </button>
+            {profiles.length > 0 && (
+              <div ref={profileMenuRef} className="relative shrink-0">
+                <button
+                  type="button"
+                  title="New Terminal With Profile"
+                  aria-haspopup="menu"
+                  aria-expanded={showProfiles}
+                  onClick={() => setShowProfiles(open => !open)}
+                  className="flex h-5 w-4 items-center justify-center rounded text-[#828997] hover:text-[#d7dce5] hover:bg-[#252a32] transition-colors duration-100"
+                >
+                  <ChevronDown size={12} />
+                </button>
+                {showProfiles && (
+                  <div
+                    role="menu"
+                    className="fixed z-50 mt-1 min-w-[160px] rounded border border-[#2b3038] bg-[#1b1f26] py-1 text-[11px] shadow-lg"
+                  >
+                    {profiles.map(profile => (
+                      <button
+                        key={profile.name}
+                        type="button"
+                        role="menuitem"
+                        title={[profile.command, ...(profile.args ?? [])].join(' ')}
+                        onClick={() => addInstance(profile.name)}
+                        className="flex w-full items-center justify-between gap-3 px-3 py-1 text-left text-[#c4cad4] hover:bg-[#252a32] hover:text-[#d7dce5]"
+                      >
+                        <span>{profile.name}</span>
+                        {profile.default && <span className="text-[#6f7784]">default</span>}
+                      </button>
+                    ))}
+                  </div>
+                )}
+              </div>
+            )}
           </div>
         </div>
 
@@ -180,7 +273,7 @@ export function TerminalPanel({ onMaximize, onMinimize, onClose, isMaximized, is
                 key={inst.id}
                 className={`h-full w-full min-w-0 overflow-hidden ${activeId === inst.id ? 'block' : 'hidden'}`}
               >
-                <Terminal id={inst.id} />
+                <Terminal id={inst.id} root={root} profile={inst.profile} />
               </div>
             ))
           }
@@ -0,0 +1,48 @@
+// Applies typed /api/watch changes to a lazily loaded file tree
+
+import { FileChange, FileNode } from '@/types/file'
+
+const parentOf = (path: string) => path.slice(0, path.lastIndexOf('/')) || '/'
+const nameOf = (path: string) => path.slice(path.lastIndexOf('/') + 1)
+
+// Only folders whose children were loaded can take new entries; the rest
+// load them fresh when expanded
+const insertNode = (nodes: FileNode[], parentPath: string, node: FileNode): FileNode[] => {
+  if (parentPath === '/') {
+    return nodes.some(existing => existing.path === node.path) ? nodes : [...nodes, node]
+  }
+  return nodes.map(existing => {
+    if (existing.path === parentPath && existing.type === 'folder') {
+      if (!existing.children) return existing
+      if (existing.children.some(child => child.path === node.path)) return existing
+      return { ...existing, children: [...existing.children, node] }
+    }
+    if (existing.children && parentPath.startsWith(existing.path + '/')) {
+      return { ...existing, children: insertNode(existing.children, parentPath, node) }
+    }
+    return existing
+  })
+}
+
+const removeNode = (nodes: FileNode[], path: string): FileNode[] =>
+  nodes
+    .filter(node => node.path !== path)
+    .map(node =>
+      node.children && path.startsWith(node.path + '/')
+        ? { ...node, children: removeNode(node.children, path) }
+        : node
+    )
+
+export function applyFileChange(tree: FileNode[], change: FileChange): FileNode[] {
+  const node: FileNode = { name: nameOf(change.path), type: change.nodeType, path: change.path }
+  switch (change.type) {
+    case 'created':
+      return insertNode(tree, parentOf(change.path), node)
+    case 'deleted':
+      return removeNode(tree, change.path)
+    case 'renamed':
+      return insertNode(removeNode(tree, change.oldPath || ''), parentOf(change.path), node)
+    default:
+      return tree
+  }
+}
@@ -5,4 +5,22 @@ export interface FileNode {
   children?: FileNode[]
   loaded?: boolean
   hasMore?: boolean
-}
\ No newline at end of file
+  ignored?: boolean
+  nextCursor?: string
+}
+// A typed change from the /api/watch stream
+export interface FileChange {
+  type: 'created' | 'modified' | 'deleted' | 'renamed'
+  path: string
+  oldPath?: string
+  nodeType: 'file' | 'folder'
+}
+// A contentChanged event for a file subscribed to on the /api/watch stream
+export interface ContentChange {
+  path: string
+  hash: string
+  mtime: string
+  size: number
+  content?: string
+  diff?: string
+}
@@ -18,6 +18,8 @@ export const config = {
   // Panel visibility flags
   showEditor: true,
   showTerminal: true,
+  // Show .gitignore'd entries dimmed in the explorer instead of hiding them
+  showIgnored: process.env.NEXT_PUBLIC_SHOW_IGNORED === 'true',
 } as const
 
 // Type for the config